@@ -0,0 +1,328 @@
+package cobalt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats understood by AccessLog.
+const (
+	// CommonLogFormat is the NCSA Common log format.
+	CommonLogFormat = `%h %l %u %t "%r" %>s %b`
+
+	// CombinedLogFormat is the NCSA Combined log format.
+	CombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+
+	// JSONLogFormat writes every request as a JSON object on its own line.
+	JSONLogFormat = "json"
+)
+
+// accessLogTime is the time layout used by %t.
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog returns middleware that writes a line to w for every request. The
+// format is CommonLogFormat, CombinedLogFormat, JSONLogFormat or a custom
+// format using these directives, which follow Apache mod_log_config:
+//
+//	%%          a literal percent sign
+//	%a %h       the client IP address
+//	%l          always "-"
+//	%u          the basic auth user or "-"
+//	%t          the time the request started
+//	%r          the request line, e.g. "GET /path HTTP/1.1"
+//	%m %U %q %H the method, path, query string and protocol
+//	%s          the status the handler set on the Context
+//	%>s         the status sent to the client
+//	%b %B       the bytes sent, "-" or 0 when there were none
+//	%D %T       the latency in microseconds and seconds
+//	%{ms}T      the latency in ms, us or s
+//	%{Name}i    the request header Name
+//	%{Name}o    the response header Name
+//	%{key}x     the value stored on the Context with SetData, for example
+//	            the status returned by an upstream service
+//
+// Writes to w are serialized. Wrap slow writers in an AsyncWriter so requests
+// don't wait on them and use a RotatingFile to rotate files by size.
+// AccessLog panics if the format is invalid.
+//
+// Example
+//
+//	c.Use(cobalt.AccessLog(os.Stdout, cobalt.CombinedLogFormat))
+func AccessLog(w io.Writer, format string) MiddleWare {
+	write := jsonAccessLog
+	if format != JSONLogFormat {
+		f, err := parseLogFormat(format)
+		if err != nil {
+			panic(err)
+		}
+		write = f.write
+	}
+
+	var mu sync.Mutex
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			st := time.Now()
+
+			defer func() {
+				// Responses without a written status are sent as 200 by
+				// net/http.
+				status := ctx.Writer().Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				// The panic is recovered, and the 500 sent, once we return.
+				p := recover()
+				if p != nil {
+					status = 500
+				}
+
+				var buf bytes.Buffer
+				write(&buf, accessEntry{ctx: ctx, start: st, latency: time.Since(st), status: status})
+				buf.WriteByte('\n')
+
+				mu.Lock()
+				w.Write(buf.Bytes())
+				mu.Unlock()
+
+				if p != nil {
+					panic(p)
+				}
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// accessEntry holds what is known about a finished request.
+type accessEntry struct {
+	ctx     *Context
+	start   time.Time
+	latency time.Duration
+	status  int
+}
+
+// user returns the basic auth user for the request.
+func (e accessEntry) user() string {
+	if u, _, ok := e.ctx.Request.BasicAuth(); ok && u != "" {
+		return u
+	}
+	return "-"
+}
+
+// clientIP returns the IP address of the client.
+func (e accessEntry) clientIP() string {
+	return e.ctx.ClientIP()
+}
+
+// logDirective writes one part of a log line.
+type logDirective func(buf *bytes.Buffer, e accessEntry)
+
+// logFormat is a parsed custom access log format.
+type logFormat []logDirective
+
+// write writes the line for e.
+func (f logFormat) write(buf *bytes.Buffer, e accessEntry) {
+	for _, d := range f {
+		d(buf, e)
+	}
+}
+
+// parseLogFormat parses a custom access log format.
+func parseLogFormat(format string) (logFormat, error) {
+	var f logFormat
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '%')
+		if i < 0 {
+			f = append(f, literal(format))
+			break
+		}
+		if i > 0 {
+			f = append(f, literal(format[:i]))
+		}
+		format = format[i+1:]
+
+		var arg string
+		if strings.HasPrefix(format, "{") {
+			end := strings.IndexByte(format, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("cobalt: unterminated %%{ in access log format")
+			}
+			arg, format = format[1:end], format[end+1:]
+		}
+
+		final := strings.HasPrefix(format, ">")
+		if final {
+			format = format[1:]
+		}
+		if format == "" {
+			return nil, fmt.Errorf("cobalt: access log format ends with %%")
+		}
+
+		d, err := directive(format[0], arg, final)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, d)
+		format = format[1:]
+	}
+	return f, nil
+}
+
+// literal writes s as is.
+func literal(s string) logDirective {
+	return func(buf *bytes.Buffer, _ accessEntry) {
+		buf.WriteString(s)
+	}
+}
+
+// directive returns the logDirective for the directive c.
+func directive(c byte, arg string, final bool) (logDirective, error) {
+	switch c {
+	case '%':
+		return literal("%"), nil
+	case 'a', 'h':
+		return func(buf *bytes.Buffer, e accessEntry) { buf.WriteString(e.clientIP()) }, nil
+	case 'l':
+		return literal("-"), nil
+	case 'u':
+		return func(buf *bytes.Buffer, e accessEntry) { escape(buf, e.user()) }, nil
+	case 't':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			buf.WriteString("[" + e.start.Format(accessLogTime) + "]")
+		}, nil
+	case 'r':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			r := e.ctx.Request
+			escape(buf, r.Method+" "+r.RequestURI+" "+r.Proto)
+		}, nil
+	case 'm':
+		return func(buf *bytes.Buffer, e accessEntry) { buf.WriteString(e.ctx.Request.Method) }, nil
+	case 'U':
+		return func(buf *bytes.Buffer, e accessEntry) { escape(buf, e.ctx.Request.URL.Path) }, nil
+	case 'q':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			if q := e.ctx.Request.URL.RawQuery; q != "" {
+				escape(buf, "?"+q)
+			}
+		}, nil
+	case 'H':
+		return func(buf *bytes.Buffer, e accessEntry) { buf.WriteString(e.ctx.Request.Proto) }, nil
+	case 's':
+		if final {
+			return func(buf *bytes.Buffer, e accessEntry) { buf.WriteString(strconv.Itoa(e.status)) }, nil
+		}
+		return func(buf *bytes.Buffer, e accessEntry) {
+			s := e.ctx.Status
+			if s == 0 {
+				s = e.status
+			}
+			buf.WriteString(strconv.Itoa(s))
+		}, nil
+	case 'b':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			if n := e.ctx.Writer().Size(); n > 0 {
+				buf.WriteString(strconv.FormatInt(n, 10))
+				return
+			}
+			buf.WriteString("-")
+		}, nil
+	case 'B':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			buf.WriteString(strconv.FormatInt(e.ctx.Writer().Size(), 10))
+		}, nil
+	case 'D':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			buf.WriteString(strconv.FormatInt(e.latency.Microseconds(), 10))
+		}, nil
+	case 'T':
+		unit := time.Second
+		switch arg {
+		case "", "s":
+		case "ms":
+			unit = time.Millisecond
+		case "us":
+			unit = time.Microsecond
+		default:
+			return nil, fmt.Errorf("cobalt: unknown time unit %q in access log format", arg)
+		}
+		return func(buf *bytes.Buffer, e accessEntry) {
+			buf.WriteString(strconv.FormatInt(int64(e.latency/unit), 10))
+		}, nil
+	case 'i':
+		return func(buf *bytes.Buffer, e accessEntry) { escape(buf, e.ctx.Request.Header.Get(arg)) }, nil
+	case 'o':
+		return func(buf *bytes.Buffer, e accessEntry) { escape(buf, e.ctx.Response.Header().Get(arg)) }, nil
+	case 'x':
+		return func(buf *bytes.Buffer, e accessEntry) {
+			if v := e.ctx.GetData(arg); v != nil {
+				escape(buf, fmt.Sprint(v))
+				return
+			}
+			buf.WriteString("-")
+		}, nil
+	}
+	return nil, fmt.Errorf("cobalt: unknown directive %%%c in access log format", c)
+}
+
+// escape writes the client supplied value v, or "-" when it is empty. Quotes
+// and control characters are escaped so values can't break up the line.
+func escape(buf *bytes.Buffer, v string) {
+	if v == "" {
+		buf.WriteString("-")
+		return
+	}
+	q := strconv.Quote(v)
+	buf.WriteString(q[1 : len(q)-1])
+}
+
+// jsonEntry is the line written by JSONLogFormat.
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Query      string  `json:"query,omitempty"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	LatencyMS  float64 `json:"latency_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+// jsonAccessLog writes the JSONLogFormat line for e.
+func jsonAccessLog(buf *bytes.Buffer, e accessEntry) {
+	r := e.ctx.Request
+	je := jsonEntry{
+		Time:       e.start.Format(time.RFC3339Nano),
+		RequestID:  e.ctx.ID,
+		RemoteAddr: e.clientIP(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Proto:      r.Proto,
+		Status:     e.status,
+		Bytes:      e.ctx.Writer().Size(),
+		LatencyMS:  float64(e.latency) / float64(time.Millisecond),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+	if u := e.user(); u != "-" {
+		je.User = u
+	}
+
+	b, _ := json.Marshal(je)
+	buf.Write(b)
+}
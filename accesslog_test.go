@@ -0,0 +1,225 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// serveLogged serves one request for path through c with the access log
+// format and returns the line written.
+func serveLogged(t *testing.T, format, path string, h cobalt.Handler) string {
+	r := request("GET", path, nil, "Referer", "http://example.com/", "User-Agent", `Go "test"`)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Proto = "HTTP/1.1"
+	r.SetBasicAuth("bill", "secret")
+
+	var buf bytes.Buffer
+	c := quiet(cobalt.AccessLog(&buf, format))
+	c.Get("/items", h)
+
+	do(c, r)
+	return buf.String()
+}
+
+// TestAccessLogCombined tests the NCSA Combined format.
+func TestAccessLogCombined(t *testing.T) {
+	line := serveLogged(t, cobalt.CombinedLogFormat, "/items?page=2", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte("hello"))
+	})
+
+	re := regexp.MustCompile(`^10\.0\.0\.1 - bill \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /items\?page=2 HTTP/1\.1" 200 5 "http://example.com/" "Go \\"test\\""\n$`)
+	if !re.MatchString(line) {
+		t.Errorf("unexpected combined log line: %s", line)
+	}
+}
+
+// TestAccessLogCustom tests a custom format with headers, latency units and
+// a value from the context.
+func TestAccessLogCustom(t *testing.T) {
+	line := serveLogged(t, `%m %U%q %s/%>s %{X-Request-Id}o %{upstream}x %{ms}T %B %{X-Missing}i %%`, "/items?page=2", func(ctx *cobalt.Context) {
+		ctx.SetData("upstream", 502)
+		ctx.ServeStatus(http.StatusBadGateway)
+	})
+
+	re := regexp.MustCompile(`^GET /items\?page=2 502/502 [0-9a-f-]{36} 502 \d+ 0 - %\n$`)
+	if !re.MatchString(line) {
+		t.Errorf("unexpected custom log line: %s", line)
+	}
+}
+
+// TestAccessLogJSON tests the JSON format.
+func TestAccessLogJSON(t *testing.T) {
+	line := serveLogged(t, cobalt.JSONLogFormat, "/items", func(ctx *cobalt.Context) {
+		ctx.ServeWithStatus(struct{ Message string }{"Created"}, http.StatusCreated)
+	})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %s", line)
+	}
+	if entry["status"] != float64(201) || entry["path"] != "/items" || entry["user"] != "bill" || entry["remote_addr"] != "10.0.0.1" {
+		t.Errorf("unexpected JSON log line: %s", line)
+	}
+}
+
+// TestAccessLogNoResponse tests requests whose handler writes nothing are
+// logged with the 200 status net/http sends.
+func TestAccessLogNoResponse(t *testing.T) {
+	line := serveLogged(t, `%>s %b`, "/items", func(ctx *cobalt.Context) {})
+	if line != "200 -\n" {
+		t.Errorf("expected a 200 status, got %q", line)
+	}
+}
+
+// TestAccessLogInvalidFormat tests an invalid format is rejected.
+func TestAccessLogInvalidFormat(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected AccessLog to panic")
+		}
+	}()
+	cobalt.AccessLog(&bytes.Buffer{}, "%Z")
+}
+
+// TestAsyncRotatingFile tests writing through an AsyncWriter to a file that
+// is rotated by size.
+func TestAsyncRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	f, err := cobalt.OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("expected no error opening file, got %v", err)
+	}
+	w := cobalt.NewAsyncWriter(f, 16)
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		w.Write([]byte(line))
+	}
+	w.Close()
+	f.Close()
+
+	if _, err := w.Write([]byte("late\n")); err == nil {
+		t.Error("expected write after close to fail")
+	}
+
+	want := map[string]string{
+		path:        "line 4\n",
+		path + ".1": "line 3\n",
+		path + ".2": "line 2\n",
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist, got %v", name, err)
+		}
+		if got := string(b); got != content {
+			t.Errorf("expected %s to hold %q, got %q", name, content, got)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected only 2 backups to be kept")
+	}
+
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("expected 3 files, got %s", strings.Join(names, ", "))
+	}
+}
+
+// TestRotatingFileNoBackups tests files without backups start over and leave
+// other files alone.
+func TestRotatingFileNoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path+".0", []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := cobalt.OpenRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatalf("expected no error opening file, got %v", err)
+	}
+	f.Write([]byte("line 1\n"))
+	f.Write([]byte("line 2\n"))
+	f.Close()
+
+	if b, _ := os.ReadFile(path); string(b) != "line 2\n" {
+		t.Errorf("expected the file to start over, got %q", b)
+	}
+	if b, _ := os.ReadFile(path + ".0"); string(b) != "other" {
+		t.Errorf("expected %s.0 to be kept, got %q", path, b)
+	}
+}
+
+// TestRotatingFileRotateFails tests writes go on to the current file when
+// it can't be rotated.
+func TestRotatingFileRotateFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	// A directory with files can't be replaced by the backup.
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := cobalt.OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("expected no error opening file, got %v", err)
+	}
+	f.Write([]byte("line 1\n"))
+	if n, err := f.Write([]byte("line 2\n")); n != 7 || err == nil {
+		t.Errorf("expected the line to be written with the rotation error, got %d %v", n, err)
+	}
+	f.Write([]byte("line 3\n"))
+	f.Close()
+
+	if b, _ := os.ReadFile(path); string(b) != "line 1\nline 2\nline 3\n" {
+		t.Errorf("expected every line in the file, got %q", b)
+	}
+}
+
+// TestRotatingFileReopen tests a file that couldn't be opened again after
+// rotating is opened by the next writes, until it is closed.
+func TestRotatingFileReopen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "access.log")
+
+	f, err := cobalt.OpenRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatalf("expected no error opening file, got %v", err)
+	}
+	f.Write([]byte("line 1\n"))
+
+	os.RemoveAll(dir)
+	if _, err := f.Write([]byte("line 2\n")); err == nil {
+		t.Error("expected an error without the directory")
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("line 3\n")); n != 7 || err != nil {
+		t.Errorf("expected the file to be opened again, got %d %v", n, err)
+	}
+	f.Close()
+
+	if _, err := f.Write([]byte("line 4\n")); err != os.ErrClosed {
+		t.Errorf("expected writes to fail once closed, got %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "line 3\n" {
+		t.Errorf("expected the line written after opening again, got %q", b)
+	}
+}
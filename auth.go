@@ -0,0 +1,255 @@
+package cobalt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Errors of authentication.
+var (
+	// ErrNoCredentials is returned by authenticators when a request has no
+	// credentials of their scheme.
+	ErrNoCredentials = errors.New("cobalt: no credentials")
+
+	// ErrInvalidCredentials is returned by authenticators when the
+	// credentials of a request are wrong.
+	ErrInvalidCredentials = errors.New("cobalt: invalid credentials")
+)
+
+// credentialErrors are the errors of requests with missing or wrong
+// credentials, which are answered with 401 Unauthorized.
+var credentialErrors = []error{
+	ErrNoCredentials, ErrInvalidCredentials, ErrReplay,
+	ErrTokenMalformed, ErrTokenSignature, ErrTokenExpired, ErrTokenNotYetValid, ErrTokenClaims, ErrUnknownKey,
+}
+
+type (
+	// Identity is who a request was authenticated as.
+	Identity struct {
+		// Name is the user, the owner of the key or the subject of the
+		// token.
+		Name string
+
+		// Scheme is the scheme the request was authenticated with, such as
+		// "basic", "apikey", "hmac" or "bearer".
+		Scheme string
+
+		// Roles and Scopes are the permissions of the identity.
+		Roles  []string
+		Scopes []string
+
+		// Claims are the claims of the token of bearer authentication.
+		Claims *Claims
+	}
+
+	// Authenticator authenticates requests with a scheme.
+	Authenticator interface {
+		// Authenticate returns the identity of the request. It returns an
+		// error wrapping ErrNoCredentials if the request has no credentials
+		// of the scheme and one wrapping ErrInvalidCredentials if they are
+		// invalid. Other errors mean the credentials couldn't be checked,
+		// such as a store failing.
+		Authenticate(ctx *Context) (*Identity, error)
+
+		// Challenge returns the WWW-Authenticate challenge of a request
+		// rejected with err, or "" if the scheme has none.
+		Challenge(err error) string
+	}
+
+	// AuthOptions configures the Authenticate middleware.
+	AuthOptions struct {
+		// Optional lets requests without credentials through without an
+		// identity, so handlers or authorization policies can decide.
+		// Requests with invalid credentials are still rejected.
+		Optional bool
+
+		// Body is encoded with the Coder and served with a 401 status to
+		// rejected requests. It defaults to a message saying the request is
+		// unauthorized.
+		Body interface{}
+
+		// OnError is called after a rejected request is answered with the
+		// reason, for example to log it.
+		OnError func(ctx *Context, err error)
+	}
+
+	// APIKeyStore looks up API keys.
+	APIKeyStore interface {
+		// Lookup returns the identity owning key, or nil if there is none.
+		Lookup(ctx context.Context, key string) (*Identity, error)
+	}
+
+	// APIKeys is an APIKeyStore of identities by their key.
+	APIKeys map[string]*Identity
+
+	// APIKeyOptions configures API key authentication.
+	APIKeyOptions struct {
+		// Store looks up the keys. It is required.
+		Store APIKeyStore
+
+		// Header is the request header holding the key. It defaults to
+		// X-API-Key.
+		Header string
+
+		// Query is the query parameter holding the key when the header is
+		// not set. Keys are not read from the query when it is empty, since
+		// URLs end up in logs.
+		Query string
+	}
+
+	// anyOf is an Authenticator trying several others.
+	anyOf []Authenticator
+
+	// apiKeyAuth authenticates requests with API keys.
+	apiKeyAuth struct {
+		o APIKeyOptions
+	}
+)
+
+// authBody is the default body served to unauthenticated requests.
+type authBody struct {
+	Error string
+}
+
+// Identity returns who the request was authenticated as, or nil if it
+// wasn't.
+func (c *Context) Identity() *Identity {
+	return c.identity
+}
+
+// Authenticate returns middleware authenticating requests with a. The
+// identity is available to handlers with Context.Identity, requests that
+// can't be authenticated are answered with 401 Unauthorized. Requests whose
+// credentials couldn't be checked, such as when the store of keys fails, are
+// answered with 500 Internal Server Error.
+//
+// Example
+//
+//	partners := c.Group("/partners", cobalt.Authenticate(cobalt.AnyOf(
+//		cobalt.APIKey(cobalt.APIKeyOptions{Store: keys}),
+//		cobalt.HMAC(cobalt.HMACOptions{Keys: secrets}),
+//	)))
+func Authenticate(a Authenticator, options ...AuthOptions) MiddleWare {
+	var o AuthOptions
+	if len(options) > 0 {
+		o = options[0]
+	}
+	if o.Body == nil {
+		o.Body = authBody{Error: "unauthorized"}
+	}
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			id, err := a.Authenticate(ctx)
+			if err != nil && !credentialError(err) {
+				ctx.ServeStatus(http.StatusInternalServerError)
+				if o.OnError != nil {
+					o.OnError(ctx, err)
+				}
+				return
+			}
+			if err != nil && !(o.Optional && errors.Is(err, ErrNoCredentials)) {
+				if challenge := a.Challenge(err); challenge != "" {
+					ctx.Response.Header().Set("WWW-Authenticate", challenge)
+				}
+				ctx.ServeWithStatus(o.Body, http.StatusUnauthorized)
+				if o.OnError != nil {
+					o.OnError(ctx, err)
+				}
+				return
+			}
+
+			if id != nil {
+				ctx.identity = id
+			}
+			h(ctx)
+		}
+	}
+}
+
+// credentialError reports whether err is the error of a request with missing
+// or wrong credentials.
+func credentialError(err error) bool {
+	for _, e := range credentialErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyOf returns an Authenticator accepting requests authenticated by any of
+// a, tried in order. Requests with invalid credentials of one scheme are
+// rejected, even if they have valid credentials of another.
+func AnyOf(a ...Authenticator) Authenticator {
+	return anyOf(a)
+}
+
+// Authenticate implements Authenticator.
+func (a anyOf) Authenticate(ctx *Context) (*Identity, error) {
+	for _, auth := range a {
+		id, err := auth.Authenticate(ctx)
+		if err == nil || !errors.Is(err, ErrNoCredentials) {
+			return id, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Challenge implements Authenticator. The challenges of all the schemes are
+// returned.
+func (a anyOf) Challenge(err error) string {
+	var challenges []string
+	for _, auth := range a {
+		if c := auth.Challenge(err); c != "" {
+			challenges = append(challenges, c)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// Lookup implements APIKeyStore.
+func (k APIKeys) Lookup(ctx context.Context, key string) (*Identity, error) {
+	return k[key], nil
+}
+
+// APIKey returns an Authenticator of requests with an API key.
+func APIKey(o APIKeyOptions) Authenticator {
+	if o.Store == nil {
+		panic("cobalt: APIKey requires an APIKeyStore")
+	}
+	if o.Header == "" {
+		o.Header = "X-API-Key"
+	}
+	return apiKeyAuth{o: o}
+}
+
+// Authenticate implements Authenticator.
+func (a apiKeyAuth) Authenticate(ctx *Context) (*Identity, error) {
+	key := ctx.Request.Header.Get(a.o.Header)
+	if key == "" && a.o.Query != "" {
+		key = ctx.Request.URL.Query().Get(a.o.Query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	id, err := a.o.Store.Lookup(ctx.Request.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	identity := *id
+	identity.Scheme = "apikey"
+	return &identity, nil
+}
+
+// Challenge implements Authenticator. API keys have no challenge.
+func (a apiKeyAuth) Challenge(err error) string {
+	return ""
+}
@@ -0,0 +1,254 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authApp returns a cobalt serving the name and scheme of the identity at /
+// and echoing the body at /echo.
+func authApp(a cobalt.Authenticator, options ...cobalt.AuthOptions) *cobalt.Cobalt {
+	c := quiet(cobalt.Authenticate(a, options...))
+	c.Get("/", func(ctx *cobalt.Context) {
+		name := "anonymous"
+		if id := ctx.Identity(); id != nil {
+			name = id.Name + " " + id.Scheme
+		}
+		ctx.ServeResponse([]byte(name), http.StatusOK, "text/plain")
+	})
+	c.Post("/echo", func(ctx *cobalt.Context) {
+		b, _ := io.ReadAll(ctx.Request.Body)
+		ctx.ServeResponse(b, http.StatusOK, "text/plain")
+	})
+	return c
+}
+
+// TestBasic tests users are authenticated with passwords and htpasswd files,
+// which can be reloaded.
+func TestBasic(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("# users\nbill:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := cobalt.LoadHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := authApp(cobalt.Basic(cobalt.BasicOptions{
+		Realm:    "admin",
+		Users:    map[string]string{"ops": "hunter2"},
+		Htpasswd: users,
+	}))
+
+	tests := []struct {
+		user, password string
+		code           int
+	}{
+		{"ops", "hunter2", http.StatusOK},
+		{"ops", "hunter", http.StatusUnauthorized},
+		{"bill", "s3cret", http.StatusOK},
+		{"bill", "wrong", http.StatusUnauthorized},
+		{"nobody", "s3cret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := NewRequest("GET", "/", nil)
+		r.SetBasicAuth(tt.user, tt.password)
+		if w := do(c, r); w.Code != tt.code {
+			t.Errorf("%s:%s: expected %d, got %d", tt.user, tt.password, tt.code, w.Code)
+		}
+	}
+
+	w := get(c, "/")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="admin", charset="UTF-8"` {
+		t.Errorf("expected a basic challenge, got %d %v", w.Code, w.Header())
+	}
+
+	if err := os.WriteFile(file, []byte("bill:plaintext\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Reload(); err == nil {
+		t.Error("expected passwords not hashed with bcrypt to be rejected")
+	}
+	hash, _ = bcrypt.GenerateFromPassword([]byte("rotated"), bcrypt.MinCost)
+	if err := os.WriteFile(file, []byte("bill:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRequest("GET", "/", nil)
+	r.SetBasicAuth("bill", "rotated")
+	if w := do(c, r); w.Code != http.StatusOK || w.Body.String() != "bill basic" {
+		t.Errorf("expected the reloaded password to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestAPIKey tests keys are read from the header or the query.
+func TestAPIKey(t *testing.T) {
+	c := authApp(cobalt.APIKey(cobalt.APIKeyOptions{
+		Store: cobalt.APIKeys{"k-123": {Name: "partner"}},
+		Query: "api_key",
+	}))
+
+	r := NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "k-123")
+	if w := do(c, r); w.Code != http.StatusOK || w.Body.String() != "partner apikey" {
+		t.Errorf("expected the key of the header to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get(c, "/?api_key=k-123"); w.Code != http.StatusOK {
+		t.Errorf("expected the key of the query to be accepted, got %d", w.Code)
+	}
+	if w := get(c, "/?api_key=k-999"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("expected an unknown key to be rejected, got %d %v", w.Code, w.Header())
+	}
+}
+
+// failingStore is an APIKeyStore that can't be reached.
+type failingStore struct{}
+
+// Lookup implements APIKeyStore.
+func (failingStore) Lookup(ctx context.Context, key string) (*cobalt.Identity, error) {
+	return nil, errors.New("store unavailable")
+}
+
+// TestAuthStoreError tests requests whose credentials can't be checked are
+// answered with a server error instead of being challenged.
+func TestAuthStoreError(t *testing.T) {
+	var reported error
+	c := authApp(cobalt.APIKey(cobalt.APIKeyOptions{Store: failingStore{}}), cobalt.AuthOptions{
+		OnError: func(ctx *cobalt.Context, err error) { reported = err },
+	})
+
+	r := NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "k-123")
+	w := do(c, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("expected a server error, got %d %v", w.Code, w.Header())
+	}
+	if reported == nil || reported.Error() != "store unavailable" {
+		t.Errorf("expected the error of the store to be reported, got %v", reported)
+	}
+}
+
+// TestAuthOptionalKeepsIdentity tests optional authentication without
+// credentials keeps the identity set by earlier authentication.
+func TestAuthOptionalKeepsIdentity(t *testing.T) {
+	keys := cobalt.APIKey(cobalt.APIKeyOptions{Store: cobalt.APIKeys{"k-123": {Name: "partner"}}})
+	users := cobalt.Basic(cobalt.BasicOptions{Users: map[string]string{"gopher": "secret"}})
+	c := authApp(users, cobalt.AuthOptions{Optional: true})
+	c.Use(cobalt.Authenticate(keys))
+
+	r := NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "k-123")
+	if w := do(c, r); w.Code != http.StatusOK || w.Body.String() != "partner apikey" {
+		t.Errorf("expected the identity of the key to be kept, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestHMAC tests signed requests are verified once within the replay window.
+func TestHMAC(t *testing.T) {
+	secret := []byte("partner-secret")
+	c := authApp(cobalt.HMAC(cobalt.HMACOptions{
+		Keys:   cobalt.HMACKeys{"partner-1": {Secret: secret, Identity: cobalt.Identity{Name: "acme"}}},
+		Window: time.Minute,
+	}))
+
+	signed := func(body, nonce string, date time.Time) *http.Request {
+		r := NewRequest("POST", "/echo?page=2", strings.NewReader(body))
+		r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+		r.Header.Set("X-Nonce", nonce)
+		if err := cobalt.SignRequest(r, "partner-1", secret); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := signed(`{"order":1}`, "1", time.Now())
+	replay := r.Clone(r.Context())
+	replay.Body = io.NopCloser(strings.NewReader(`{"order":1}`))
+	if w := do(c, r); w.Code != http.StatusOK || w.Body.String() != `{"order":1}` {
+		t.Errorf("expected the signed request to be served with its body, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(c, replay); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the replayed request to be rejected, got %d", w.Code)
+	}
+	if w := do(c, signed(`{"order":1}`, "2", time.Now())); w.Code != http.StatusOK {
+		t.Errorf("expected a request with another nonce to be served, got %d", w.Code)
+	}
+
+	if w := do(c, signed("{}", "3", time.Now().Add(-2*time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an old request to be rejected, got %d", w.Code)
+	}
+
+	tampered := signed(`{"order":1}`, "4", time.Now())
+	tampered.Body = io.NopCloser(bytes.NewReader([]byte(`{"order":2}`)))
+	if w := do(c, tampered); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "HMAC-SHA256" {
+		t.Errorf("expected a tampered body to be rejected, got %d %v", w.Code, w.Header())
+	}
+
+	tampered = signed("{}", "5", time.Now())
+	tampered.URL.RawQuery = "page=3"
+	if w := do(c, tampered); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a tampered query to be rejected, got %d", w.Code)
+	}
+}
+
+// TestAnyOf tests routes accept any of several schemes and reject invalid
+// credentials of any of them.
+func TestAnyOf(t *testing.T) {
+	var failed error
+	c := authApp(cobalt.AnyOf(
+		cobalt.Bearer(cobalt.JWTOptions{Keys: cobalt.StaticKeys{"": []byte("secret")}}),
+		cobalt.APIKey(cobalt.APIKeyOptions{Store: cobalt.APIKeys{"k-123": {Name: "partner", Roles: []string{"reader"}}}}),
+		cobalt.Basic(cobalt.BasicOptions{Users: map[string]string{"ops": "hunter2"}}),
+	), cobalt.AuthOptions{
+		Optional: true,
+		OnError:  func(ctx *cobalt.Context, err error) { failed = err },
+	})
+
+	r := NewRequest("GET", "/", nil)
+	r.SetBasicAuth("ops", "hunter2")
+	if w := do(c, r); w.Body.String() != "ops basic" {
+		t.Errorf("expected basic authentication, got %d %s", w.Code, w.Body.String())
+	}
+
+	r = NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, cobalt.HS256, "", []byte("secret"), claims()))
+	if w := do(c, r); w.Body.String() != "user-1 bearer" {
+		t.Errorf("expected bearer authentication, got %d %s", w.Code, w.Body.String())
+	}
+
+	r = NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "k-123")
+	if w := do(c, r); w.Body.String() != "partner apikey" {
+		t.Errorf("expected API key authentication, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := get(c, "/"); w.Code != http.StatusOK || w.Body.String() != "anonymous" {
+		t.Errorf("expected an anonymous request, got %d %s", w.Code, w.Body.String())
+	}
+
+	r = NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "k-999")
+	r.SetBasicAuth("ops", "hunter2")
+	w := do(c, r)
+	if w.Code != http.StatusUnauthorized || failed != cobalt.ErrInvalidCredentials {
+		t.Errorf("expected the invalid key to be rejected, got %d %v", w.Code, failed)
+	}
+	if ch := w.Header().Get("WWW-Authenticate"); !strings.Contains(ch, "Bearer") || !strings.Contains(ch, "Basic") {
+		t.Errorf("expected the challenges of all schemes, got %q", ch)
+	}
+}
@@ -0,0 +1,233 @@
+package cobalt
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+type (
+	// Policy is a rule deciding whether an authenticated identity may make a
+	// request.
+	Policy struct {
+		// Name describes the policy in route introspection, such as
+		// "role(admin)".
+		Name string
+
+		// Allow reports whether id may make the request. id is nil for
+		// requests that weren't authenticated.
+		Allow func(ctx *Context, id *Identity) bool
+
+		// anonymous is set for policies that allow requests without an
+		// identity.
+		anonymous bool
+	}
+
+	// RouteInfo describes a route for auditing.
+	RouteInfo struct {
+		Method string
+		Path   string
+
+		// Policies are the names of the policies required by the route, from
+		// global middleware, its groups and the route itself.
+		Policies []string
+
+		// Protected is set if the route requires a policy other than Public.
+		Protected bool
+	}
+
+	// routeEntry is a route as it was added, with the policies required by
+	// its middleware and its CORS middleware. Files served with ServeFiles
+	// don't run any middleware.
+	routeEntry struct {
+		method   string
+		path     string
+		policies []Policy
+		cors     *corsHandler
+		files    bool
+	}
+
+	// requirement is the policies of a Require middleware.
+	requirement []Policy
+)
+
+// Middleware is a function, which can't be compared or inspected, so the
+// middleware recorded by routes is a method value of requirement or
+// corsHandler, recognized by the code of the method.
+var (
+	requireCode = reflect.ValueOf(MiddleWare(requirement(nil).wrap)).Pointer()
+	corsCode    = reflect.ValueOf(MiddleWare((*corsHandler)(nil).wrap)).Pointer()
+)
+
+// Role returns a Policy allowing identities with any of roles.
+func Role(roles ...string) Policy {
+	return Policy{
+		Name: "role(" + strings.Join(roles, ", ") + ")",
+		Allow: func(ctx *Context, id *Identity) bool {
+			return id != nil && containsAny(id.Roles, roles)
+		},
+	}
+}
+
+// Scope returns a Policy allowing identities granted all of scopes.
+func Scope(scopes ...string) Policy {
+	return Policy{
+		Name: "scope(" + strings.Join(scopes, ", ") + ")",
+		Allow: func(ctx *Context, id *Identity) bool {
+			if id == nil {
+				return false
+			}
+			for _, s := range scopes {
+				if !containsAny(id.Scopes, []string{s}) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// Authenticated returns a Policy allowing any authenticated identity.
+func Authenticated() Policy {
+	return Policy{
+		Name: "authenticated",
+		Allow: func(ctx *Context, id *Identity) bool {
+			return id != nil
+		},
+	}
+}
+
+// Public returns a Policy allowing every request, authenticated or not. It
+// marks routes that are meant to be public, so audits can tell them apart
+// from routes where a policy was forgotten.
+func Public() Policy {
+	return Policy{
+		Name: "public",
+		Allow: func(ctx *Context, id *Identity) bool {
+			return true
+		},
+		anonymous: true,
+	}
+}
+
+// Allow returns a Policy allowing requests for which f returns true, such as
+// requests of the owner of a resource. name describes it in route
+// introspection.
+func Allow(name string, f func(ctx *Context, id *Identity) bool) Policy {
+	return Policy{Name: name, Allow: f}
+}
+
+// Require returns middleware authorizing requests with policies, which must
+// all allow a request. Policies are checked after all the middleware of the
+// route ran, right before the handler, so they see the identity set by the
+// authentication middleware wherever it was added. Requests without an
+// identity are answered with 401 Unauthorized and requests of identities that
+// aren't allowed with 403 Forbidden.
+//
+// Policies added with Require are listed by Routes when it is passed to Use,
+// Group or the methods adding routes, not wrapped by other middleware.
+//
+// Example
+//
+//	c.Use(cobalt.JWT(jwtOptions))
+//	admin := c.Group("/admin", cobalt.Require(cobalt.Role("admin")))
+//	admin.Delete("/users/:id", deleteUser, cobalt.Require(cobalt.Scope("users:write")))
+func Require(policies ...Policy) MiddleWare {
+	for _, p := range policies {
+		if p.Allow == nil {
+			panic(fmt.Sprintf("cobalt: policy %q has no Allow function", p.Name))
+		}
+	}
+
+	return requirement(policies).wrap
+}
+
+// wrap adds the policies of r to the ones checked before h runs.
+func (r requirement) wrap(h Handler) Handler {
+	return func(ctx *Context) {
+		ctx.policies = append(ctx.policies, r...)
+		h(ctx)
+	}
+}
+
+// recordRoute returns the policies required by the Require middleware among m,
+// in the order they are checked, and the CORS middleware closest to the
+// route, when routes are added. Only the middleware of Require and CORS runs,
+// on an empty Context it records itself in. Middleware wrapping them inside
+// other middleware isn't recorded.
+func recordRoute(m []MiddleWare) ([]Policy, *corsHandler) {
+	var ctx Context
+	noop := func(*Context) {}
+
+	// Middleware is applied in order, so the last one runs first.
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i] == nil {
+			continue
+		}
+		switch reflect.ValueOf(m[i]).Pointer() {
+		case requireCode, corsCode:
+			m[i](noop)(&ctx)
+		}
+	}
+	return ctx.policies, ctx.cors
+}
+
+// authorize checks the policies required by the middleware of the request,
+// answering the request if they don't allow it.
+func (c *Context) authorize() bool {
+	id := c.Identity()
+	for _, p := range c.policies {
+		if p.Allow(c, id) {
+			continue
+		}
+
+		if id == nil && !p.anonymous {
+			c.ServeWithStatus(authBody{Error: "unauthorized"}, http.StatusUnauthorized)
+			return false
+		}
+		c.ServeWithStatus(authBody{Error: "forbidden"}, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Routes returns the routes of c in the order they were added, with the
+// policies they require.
+//
+// Example
+//
+//	for _, r := range c.Routes() {
+//		if !r.Protected {
+//			log.Printf("unprotected route: %s %s", r.Method, r.Path)
+//		}
+//	}
+func (c *Cobalt) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(c.routes))
+	for _, r := range c.routes {
+		var policies []Policy
+		if !r.files {
+			policies = append(append(policies, c.policies...), r.policies...)
+		}
+
+		info := RouteInfo{Method: r.method, Path: r.path}
+		for _, p := range policies {
+			info.Policies = append(info.Policies, p.Name)
+			info.Protected = info.Protected || !p.anonymous
+		}
+		routes = append(routes, info)
+	}
+	return routes
+}
+
+// containsAny reports whether list contains any of values.
+func containsAny(list, values []string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// authzApp returns a cobalt authenticating API keys with policies on a group
+// and its routes.
+func authzApp() *cobalt.Cobalt {
+	c := quiet()
+	c.Use(cobalt.Authenticate(cobalt.APIKey(cobalt.APIKeyOptions{Store: cobalt.APIKeys{
+		"admin":  {Name: "ada", Roles: []string{"admin"}, Scopes: []string{"users:read", "users:write"}},
+		"reader": {Name: "bob", Roles: []string{"admin"}, Scopes: []string{"users:read"}},
+		"user":   {Name: "eve", Roles: []string{"user"}},
+	}}), cobalt.AuthOptions{Optional: true}))
+
+	handler := func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}
+	c.Get("/health", handler, cobalt.Require(cobalt.Public()))
+	c.Get("/debug", handler)
+
+	admin := c.Group("/admin", cobalt.Require(cobalt.Role("admin")))
+	admin.Get("/users", handler)
+	admin.Delete("/users/:id", handler, cobalt.Require(cobalt.Scope("users:write")))
+
+	c.Get("/profile/:name", handler, cobalt.Require(cobalt.Authenticated(), cobalt.Allow("owner", func(ctx *cobalt.Context, id *cobalt.Identity) bool {
+		return strings.TrimPrefix(ctx.Request.URL.Path, "/profile/") == id.Name
+	})))
+	c.ServeFiles("/static/*filepath", http.Dir("."))
+	return c
+}
+
+// TestRequire tests requests without an identity are unauthorized and
+// identities without the permissions are forbidden.
+func TestRequire(t *testing.T) {
+	c := authzApp()
+
+	tests := []struct {
+		method, path, key string
+		code              int
+	}{
+		{"GET", "/health", "", http.StatusOK},
+		{"GET", "/admin/users", "", http.StatusUnauthorized},
+		{"GET", "/admin/users", "user", http.StatusForbidden},
+		{"GET", "/admin/users", "reader", http.StatusOK},
+		{"DELETE", "/admin/users/1", "reader", http.StatusForbidden},
+		{"DELETE", "/admin/users/1", "admin", http.StatusOK},
+		{"GET", "/profile/eve", "user", http.StatusOK},
+		{"GET", "/profile/ada", "user", http.StatusForbidden},
+		{"GET", "/profile/eve", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := NewRequest(tt.method, tt.path, nil)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		if w := do(c, r); w.Code != tt.code {
+			t.Errorf("%s %s as %q: expected %d, got %d %s", tt.method, tt.path, tt.key, tt.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestRoutesPolicies tests routes are listed with the policies of their groups and
+// unprotected routes can be found.
+func TestRoutesPolicies(t *testing.T) {
+	c := authzApp()
+
+	var unprotected []string
+	policies := make(map[string][]string)
+	for _, r := range c.Routes() {
+		policies[r.Method+" "+r.Path] = r.Policies
+		if !r.Protected {
+			unprotected = append(unprotected, r.Method+" "+r.Path)
+		}
+	}
+
+	expected := map[string][]string{
+		"GET /health":             {"public"},
+		"GET /debug":              nil,
+		"GET /admin/users":        {"role(admin)"},
+		"DELETE /admin/users/:id": {"role(admin)", "scope(users:write)"},
+		"GET /profile/:name":      {"authenticated", "owner"},
+		"GET /static/*filepath":   nil,
+	}
+	if !reflect.DeepEqual(policies, expected) {
+		t.Errorf("expected the policies of the routes, got %v", policies)
+	}
+	if !reflect.DeepEqual(unprotected, []string{"GET /health", "GET /debug", "GET /static/*filepath"}) {
+		t.Errorf("expected the unprotected routes, got %v", unprotected)
+	}
+
+	// Global policies apply to routes added before them too.
+	c.Use(cobalt.Require(cobalt.Authenticated()))
+	if r := c.Routes()[1]; !r.Protected || r.Policies[0] != "authenticated" {
+		t.Errorf("expected the global policy, got %+v", r)
+	}
+	if w := get(c, "/debug"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the global policy to be enforced, got %d", w.Code)
+	}
+}
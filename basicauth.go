@@ -0,0 +1,159 @@
+package cobalt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// BasicOptions configures HTTP Basic authentication.
+	BasicOptions struct {
+		// Realm is sent in the WWW-Authenticate header of rejected requests.
+		// It defaults to "restricted".
+		Realm string
+
+		// Users are the passwords of users. They are compared in constant
+		// time.
+		Users map[string]string
+
+		// Htpasswd holds bcrypt hashed passwords of users, checked for users
+		// not in Users.
+		Htpasswd *Htpasswd
+
+		// Roles are the roles of users.
+		Roles map[string][]string
+	}
+
+	// Htpasswd is a password file in the format of Apache's htpasswd, with
+	// passwords hashed with bcrypt ("htpasswd -B"). The file can be reloaded
+	// at runtime with Reload.
+	Htpasswd struct {
+		path string
+
+		mu     sync.RWMutex
+		hashes map[string][]byte
+	}
+
+	// basicAuth authenticates requests with HTTP Basic authentication.
+	basicAuth struct {
+		o BasicOptions
+	}
+)
+
+// dummyHash is compared against the passwords of unknown users, so they take
+// as long to reject as known users with a wrong password. It is generated when
+// first needed.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("cobalt"), bcrypt.DefaultCost)
+	return hash
+})
+
+// LoadHtpasswd loads a password file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Reload loads the password file from disk. The previous passwords are kept
+// if it fails.
+func (h *Htpasswd) Reload() error {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string][]byte)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("cobalt: %s:%d: malformed line", h.path, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("cobalt: %s:%d: password of %s is not hashed with bcrypt", h.path, n, user)
+		}
+		hashes[user] = []byte(hash)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hashes = hashes
+	return nil
+}
+
+// Verify reports whether password is the password of user.
+func (h *Htpasswd) Verify(user, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.hashes[user]
+	h.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Basic returns an Authenticator of requests with HTTP Basic authentication.
+//
+// Example
+//
+//	users, err := cobalt.LoadHtpasswd("/etc/app/htpasswd")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	c.Use(cobalt.Authenticate(cobalt.Basic(cobalt.BasicOptions{Htpasswd: users})))
+func Basic(o BasicOptions) Authenticator {
+	if o.Realm == "" {
+		o.Realm = "restricted"
+	}
+	return basicAuth{o: o}
+}
+
+// Authenticate implements Authenticator.
+func (a basicAuth) Authenticate(ctx *Context) (*Identity, error) {
+	user, password, ok := ctx.Request.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	if !a.verify(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Name: user, Scheme: "basic", Roles: a.o.Roles[user]}, nil
+}
+
+// verify reports whether password is the password of user.
+func (a basicAuth) verify(user, password string) bool {
+	if expected, ok := a.o.Users[user]; ok {
+		// Comparing hashes doesn't leak the length of the password.
+		x := sha256.Sum256([]byte(expected))
+		y := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+	}
+	if a.o.Htpasswd != nil {
+		return a.o.Htpasswd.Verify(user, password)
+	}
+	return false
+}
+
+// Challenge implements Authenticator.
+func (a basicAuth) Challenge(err error) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.o.Realm)
+}
@@ -76,3 +76,26 @@ func BenchmarkContextRequest(b *testing.B) {
 	}
 	b.ReportAllocs()
 }
+
+func BenchmarkCompress(b *testing.B) {
+	c := cobalt.New(&JSONEncoder{})
+	c.DisableRequestLog = true
+	c.Use(cobalt.Compress())
+
+	h := func(ctx *cobalt.Context) {
+		ctx.ServeResponse([]byte(data), 200, "application/json")
+	}
+
+	c.Get("/", h)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		r := NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		b.StartTimer()
+		c.ServeHTTP(w, r)
+	}
+	b.ReportAllocs()
+}
@@ -0,0 +1,201 @@
+package cobalt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certWatchInterval is how often servers started with a CertFile and KeyFile
+// check the files for changes.
+const certWatchInterval = 30 * time.Second
+
+// CertOptions configures a CertManager.
+type CertOptions struct {
+	// Logger logs the reloads of the certificate by Watch. It defaults to a
+	// Logger writing to stdout.
+	Logger Logger
+}
+
+// CertManager serves a TLS certificate loaded from a cert and key file. The
+// certificate is reloaded when Reload is called, when the files change while
+// Watch is running, or when a server run by cobalt receives SIGHUP. This
+// allows certificates to be rotated without a restart.
+type CertManager struct {
+	certfile string
+	keyfile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+
+	log Logger
+}
+
+// NewCertManager creates a CertManager and loads the certificate. You may
+// also provide a single optional argument of type CertOptions.
+func NewCertManager(certfile, keyfile string, options ...CertOptions) (*CertManager, error) {
+	var o CertOptions
+	if len(options) > 0 {
+		o = options[0]
+	}
+	if o.Logger == nil {
+		o.Logger = defaultLogger
+	}
+
+	m := CertManager{certfile: certfile, keyfile: keyfile, log: o.Logger}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetCertificate returns the current certificate. It has the signature of
+// tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// Reload loads the certificate from disk. The previous certificate is kept
+// if the files can not be loaded.
+func (m *CertManager) Reload() error {
+	certMod, keyMod, err := m.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certfile, m.keyfile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = &cert
+	m.certMod = certMod
+	m.keyMod = keyMod
+	return nil
+}
+
+// Watch checks the cert and key files every interval and reloads the
+// certificate when either changes. It blocks until stop is closed.
+func (m *CertManager) Watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				m.log.Error("could not reload certificate", Field{"file", m.certfile}, Field{"error", err})
+				continue
+			}
+			m.log.Info("reloaded certificate", Field{"file", m.certfile})
+		}
+	}
+}
+
+// changed reports whether the files were modified since the last load.
+func (m *CertManager) changed() bool {
+	certMod, keyMod, err := m.modTimes()
+	if err != nil {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !certMod.Equal(m.certMod) || !keyMod.Equal(m.keyMod)
+}
+
+// modTimes returns the modification times of the cert and key files.
+func (m *CertManager) modTimes() (time.Time, time.Time, error) {
+	ci, err := os.Stat(m.certfile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	ki, err := os.Stat(m.keyfile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return ci.ModTime(), ki.ModTime(), nil
+}
+
+// LoadCertPool creates a certificate pool from PEM encoded files. It is used
+// to build the pool of CAs trusted to sign client certificates.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", f)
+		}
+	}
+	return pool, nil
+}
+
+// errNoClientCAs is returned when client certificates must be verified but no
+// CAs were configured to verify them with.
+var errNoClientCAs = errors.New("client certificate verification requires ClientCAs")
+
+// errClientAuthNoTLS is returned when client certificates are requested by a
+// server that isn't served over TLS.
+var errClientAuthNoTLS = errors.New("client certificates require TLS")
+
+// ClientIdentity describes the verified certificate a client presented when
+// connecting over mutual TLS.
+type ClientIdentity struct {
+	// Subject is the distinguished name of the certificate subject.
+	Subject string
+
+	// CommonName is the common name of the certificate subject.
+	CommonName string
+
+	// Subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+
+	// Certificate is the verified leaf certificate.
+	Certificate *x509.Certificate
+}
+
+// ClientIdentity returns the identity from the verified client certificate of
+// the request or nil if the client did not present one that was verified.
+func (c *Context) ClientIdentity() *ClientIdentity {
+	cs := c.Request.TLS
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := cs.VerifiedChains[0][0]
+	id := ClientIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		Certificate:    cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+
+	return &id
+}
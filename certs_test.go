@@ -0,0 +1,209 @@
+package cobalt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCert generates a certificate for cn signed by parent. A nil parent
+// creates a self signed CA.
+func newCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error generating key, got %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"cobalt"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := &tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.ExtKeyUsage = nil
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("expected no error creating certificate, got %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kb, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}),
+	}
+}
+
+// write writes the certificate and key into dir.
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	certfile := filepath.Join(dir, "cert.pem")
+	keyfile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certfile, c.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyfile, c.keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certfile, keyfile
+}
+
+// TestCertManagerWatch tests the certificate is reloaded when the files change.
+func TestCertManagerWatch(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	first := newCert(t, "first", ca, x509.ExtKeyUsageServerAuth)
+	second := newCert(t, "second", ca, x509.ExtKeyUsageServerAuth)
+
+	certfile, keyfile := first.write(t, dir)
+	m, err := cobalt.NewCertManager(certfile, keyfile, cobalt.CertOptions{
+		Logger: cobalt.NewStdLogger(log.New(io.Discard, "", 0)),
+	})
+	if err != nil {
+		t.Fatalf("expected no error creating manager, got %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go m.Watch(10*time.Millisecond, stop)
+
+	second.write(t, dir)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certfile, future, future)
+	os.Chtimes(keyfile, future, future)
+
+	for i := 0; i < 100; i++ {
+		cert, _ := m.GetCertificate(nil)
+		if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName == "second" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected certificate to be reloaded")
+}
+
+// TestMutualTLS tests client certificates are verified and exposed on the
+// context, and that SIGHUP reloads the server certificate.
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	first := newCert(t, "first", ca, x509.ExtKeyUsageServerAuth)
+	second := newCert(t, "second", ca, x509.ExtKeyUsageServerAuth)
+	client := newCert(t, "client.example.com", ca, x509.ExtKeyUsageClientAuth)
+
+	certfile, keyfile := first.write(t, dir)
+	cafile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(cafile, ca.certPEM, 0600)
+
+	pool, err := cobalt.LoadCertPool(cafile)
+	if err != nil {
+		t.Fatalf("expected no error loading pool, got %v", err)
+	}
+
+	c := cobalt.New(&JSONEncoder{})
+	c.Get("/", func(ctx *cobalt.Context) {
+		id := ctx.ClientIdentity()
+		if id == nil {
+			ctx.ServeStatus(http.StatusForbidden)
+			return
+		}
+		ctx.Response.Write([]byte(id.CommonName + " " + id.DNSNames[0]))
+	})
+
+	ln := listen(t)
+	c.AddServer(cobalt.Server{
+		Listener:   ln,
+		CertFile:   certfile,
+		KeyFile:    keyfile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		c.Run("127.0.0.1:0", time.Second, time.Second)
+		close(done)
+	}()
+
+	var served string
+	clientCert, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{clientCert},
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				served = cs.PeerCertificates[0].Subject.CommonName
+				return nil
+			},
+		},
+	}
+	url := "https://" + ln.Addr().String() + "/"
+
+	if got := body(t, waitFor(t, &http.Client{Transport: transport}, url)); got != "client.example.com client.example.com" {
+		t.Errorf("expected client identity, got %s", got)
+	}
+	if served != "first" {
+		t.Errorf("expected first certificate to be served, got %s", served)
+	}
+
+	anon := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if _, err := anon.Get(url); err == nil {
+		t.Error("expected request without client certificate to fail")
+	}
+
+	second.write(t, dir)
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+
+	for i := 0; i < 100 && served != "second"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resp, err := (&http.Client{Transport: transport}).Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	if served != "second" {
+		t.Errorf("expected second certificate after SIGHUP, got %s", served)
+	}
+
+	stop(t, done)
+}
@@ -1,14 +1,8 @@
 package cobalt
 
 import (
-	"context"
 	"io"
-	"log"
 	"net/http"
-	"os"
-	"os/signal"
-	"runtime"
-	"syscall"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -29,12 +23,40 @@ type (
 	Cobalt struct {
 		router      *httprouter.Router
 		global      []MiddleWare
+		policies    []Policy
+		globalCORS  *corsHandler
 		serverError Handler
 		cors        Handler
 		coder       Coder
+		servers     []Server
+		tracker     *Tracker
+		methods     map[string]bool
+		routes      []routeEntry
 
 		// Templates is the configuration for HTML templates served by cobalt.
 		Templates Templates
+
+		// Logger receives the logs written by cobalt. It defaults to a Logger
+		// writing to stdout.
+		Logger Logger
+
+		// DisableRequestLog turns off the entries logged at the start and
+		// completion of every request.
+		DisableRequestLog bool
+
+		// RequestID configures how the ID of every request is assigned.
+		RequestID RequestIDOptions
+
+		// Recovery configures how panics in handlers are handled.
+		Recovery RecoveryOptions
+
+		// Proxy configures the proxies trusted to report the client IP,
+		// scheme and host of requests in forwarding headers.
+		Proxy ProxyOptions
+
+		// Cookies configures the cookies set with Context.SetCookie and the
+		// keys of signed and encrypted cookies.
+		Cookies CookieOptions
 	}
 
 	// Handler represents a request handler that is called by cobalt
@@ -46,7 +68,7 @@ type (
 
 // New creates a new instance of cobalt.
 func New(coder Coder) *Cobalt {
-	return &Cobalt{router: httprouter.New(), coder: coder, Templates: DefaultTemplates()}
+	return &Cobalt{router: httprouter.New(), coder: coder, tracker: newTracker(), Templates: DefaultTemplates()}
 }
 
 // Coder returns the Coder configured in Cobalt
@@ -54,11 +76,29 @@ func (c *Cobalt) Coder() Coder {
 	return c.coder
 }
 
-// CORS sets the handler for serving and processing cors.
+// CORS sets the handler for serving and processing cors. It is called for
+// every OPTIONS request instead of the router.
+//
+// Deprecated: use the CORS middleware, which also sets the CORS headers of
+// actual requests and answers preflight requests per route.
 func (c *Cobalt) CORS(h Handler) {
 	c.cors = h
 }
 
+// Use adds middleware that is run for every route. It runs before the
+// middleware of groups and routes, so it sees every request, including the
+// ones they reject.
+func (c *Cobalt) Use(m ...MiddleWare) {
+	c.global = append(c.global, m...)
+
+	// Middleware added later runs first.
+	policies, cors := recordRoute(m)
+	c.policies = append(policies, c.policies...)
+	if c.globalCORS == nil {
+		c.globalCORS = cors
+	}
+}
+
 // ServerErr sets the handler for a server err.
 func (c *Cobalt) ServerErr(h Handler) {
 	c.serverError = h
@@ -67,61 +107,122 @@ func (c *Cobalt) ServerErr(h Handler) {
 // NotFound sets a not found handler.
 func (c *Cobalt) NotFound(h Handler) {
 	t := func(w http.ResponseWriter, req *http.Request) {
-		ctx := NewContext(req, w, nil, c.coder, c.Templates)
+		ctx := c.newContext(req, w, nil)
 		h(ctx)
 	}
 
 	c.router.NotFound = http.HandlerFunc(t)
 }
 
+// newContext creates the context for a request served by c.
+func (c *Cobalt) newContext(req *http.Request, w http.ResponseWriter, p httprouter.Params) *Context {
+	ctx := newContext(req, w, p, c.coder, c.Templates, c.RequestID)
+	ctx.logger = c.logger()
+	ctx.proxy = c.Proxy
+	ctx.cookies = c.Cookies
+	return ctx
+}
+
 // route adds a handler with middleware for a route and method. It builds a
 // function which is then passed to the router.
 func (c *Cobalt) route(method, route string, h Handler, m []MiddleWare) {
+	entry := routeEntry{method: method, path: route}
+	entry.policies, entry.cors = recordRoute(m)
 
 	f := func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		cors := entry.cors
+		if cors == nil {
+			cors = c.globalCORS
+		}
+
+		// Preflight requests are answered before any middleware runs, and
+		// left to the router by routes without CORS middleware.
+		if pf, ok := req.Context().Value(preflightKey{}).(*preflightRequest); ok {
+			if cors != nil {
+				ctx := c.newContext(req, w, p)
+				ctx.route = route
+				cors.answer(ctx, pf.methods)
+				pf.answered = true
+			}
+			return
+		}
+
 		st := time.Now()
-		ctx := NewContext(req, w, p, c.coder, c.Templates)
+		ctx := c.newContext(req, w, p)
+		ctx.route = route
+		if cors != nil {
+			cors.setHeaders(ctx)
+		}
+
+		c.tracker.start(ctx, st)
+		defer c.tracker.done(ctx)
+
+		defer func() {
+			// Handlers writing to the response directly don't set the status.
+			if ctx.Status == 0 {
+				ctx.Status = ctx.writer.Status()
+			}
+
+			if !c.DisableRequestLog {
+				ctx.Logger().Info("request completed",
+					Field{"method", req.Method},
+					Field{"path", req.RequestURI},
+					Field{"status", ctx.Status},
+					Field{"bytes", ctx.writer.Size()},
+					Field{"ttfb", ctx.writer.TimeToFirstByte()},
+					Field{"latency", time.Since(st)},
+					Field{"remote_addr", req.RemoteAddr},
+					Field{"client_ip", ctx.ClientIP()},
+				)
+			}
+		}()
 
 		// Handle panics
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("cobalt: Panic, Recovering\n")
-				log.Println(r)
-				buf := make([]byte, 10000)
-				runtime.Stack(buf, false)
-				log.Printf("%s\n", string(buf))
-				if c.serverError != nil {
-					c.serverError(ctx)
-				}
-				if c.serverError == nil {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
+				c.recovered(ctx, r)
 			}
-
-			log.Printf("Request %s complete [%s] =>  %s %s - %s", ctx.ID, time.Since(st), req.Method, req.RequestURI, req.RemoteAddr)
 		}()
 
-		log.Printf("Request %s start =>  %s %s - %s", ctx.ID, req.Method, req.RequestURI, req.RemoteAddr)
+		if !c.DisableRequestLog {
+			ctx.Logger().Info("request started",
+				Field{"method", req.Method},
+				Field{"path", req.RequestURI},
+				Field{"remote_addr", req.RemoteAddr},
+				Field{"client_ip", ctx.ClientIP()},
+			)
+		}
 
-		w.Header().Set(idHeader, ctx.ID)
+		w.Header().Set(c.RequestID.header(), ctx.ID)
 
 		mwchain := func(h Handler) Handler {
-			// global middleware.
-			for idx := range c.global {
-				h = c.global[idx](h)
-			}
-
 			// route specific middleware
 			for idx := range m {
 				h = m[idx](h)
 			}
+
+			// global middleware, applied last so it runs first.
+			for idx := range c.global {
+				h = c.global[idx](h)
+			}
 			return h
 		}
 
+		handler := func(ctx *Context) {
+			if ctx.checkCSRF() && ctx.authorize() {
+				h(ctx)
+			}
+		}
+
 		// process request
-		mwchain(h)(ctx)
+		mwchain(handler)(ctx)
 	}
 
+	if c.methods == nil {
+		c.methods = make(map[string]bool)
+	}
+	c.methods[method] = true
+	c.routes = append(c.routes, entry)
 	c.router.Handle(method, route, f)
 }
 
@@ -163,6 +264,7 @@ func (c *Cobalt) Head(route string, h Handler, m ...MiddleWare) {
 //
 //	c.ServeFiles("public/*filepath", http.Dir("public"))
 func (c *Cobalt) ServeFiles(path string, root http.FileSystem) {
+	c.routes = append(c.routes, routeEntry{method: "GET", path: path, files: true})
 	c.router.ServeFiles(path, root)
 }
 
@@ -170,79 +272,17 @@ func (c *Cobalt) ServeFiles(path string, root http.FileSystem) {
 func (c *Cobalt) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// if method is options and handler set treat as preflight CORS request. Call the CORS handler.
 	if c.cors != nil && req.Method == "OPTIONS" {
-		ctx := NewContext(req, w, nil, c.coder, c.Templates)
+		ctx := c.newContext(req, w, nil)
 		c.cors(ctx)
 		return
 	}
 
-	// Otherwise just pass it on.
-	c.router.ServeHTTP(w, req)
-}
-
-// Run runs the dispatcher which starts an http server to listen and serve.
-// This operation blocks until an Inerrupt or SIGTERM signal is received at
-// which point it starts a 10 second graceful shutdown. If requests do not
-// finish in that time the whole application exits.
-func (c *Cobalt) Run(addr string, readtimeout, writetimeout time.Duration) {
-	c.run(addr, "", "", readtimeout, writetimeout)
-}
-
-// RunTLS runs the dispatcher with a TLS cert. It blocks waiting for a signal
-// and performs graceful shutdown just like Run.
-func (c *Cobalt) RunTLS(addr, certfile, keyfile string, readtimeout, writetimeout time.Duration) {
-	c.run(addr, certfile, keyfile, readtimeout, writetimeout)
-}
-
-func (c *Cobalt) run(addr, certfile, keyfile string, readtimeout, writetimeout time.Duration) {
-	log.SetOutput(os.Stdout)
-	log.SetFlags(0)
-	log.SetPrefix("[cobalt] ")
-	log.Printf("starting, listening on %s", addr)
-
-	srv := &http.Server{
-		ReadTimeout:  readtimeout,
-		WriteTimeout: writetimeout,
-		Addr:         addr,
-		Handler:      c,
+	// CORS preflight requests are answered by the CORS middleware of the
+	// route they ask for, if it has any.
+	if req.Method == "OPTIONS" && c.preflight(w, req) {
+		return
 	}
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
-
-	// Start the service listening for requests.
-	go func() {
-		if certfile == "" && keyfile == "" {
-			serverErrors <- srv.ListenAndServe()
-		} else {
-			serverErrors <- srv.ListenAndServeTLS(certfile, keyfile)
-		}
-	}()
-
-	shutdownTimeout := 10 * time.Second
-
-	// Make a channel to listen for an interrupt or terminate signal from the OS.
-	// Use a buffered channel because the signal package requires it.
-	osSignals := make(chan os.Signal, 1)
-	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
-
-	// Blocking waiting for shutdown or an error
-	select {
-	case err := <-serverErrors:
-		log.Fatalf("Error starting server: %v", err)
-
-	case <-osSignals:
-
-		// Create context for Shutdown call.
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
-
-		// Asking listener to shutdown and load shed.
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Graceful shutdown did not complete in %v : %v", shutdownTimeout, err)
-			if err := srv.Close(); err != nil {
-				log.Fatalf("Could not stop http server: %v", err)
-			}
-		}
-	}
+	// Otherwise just pass it on.
+	c.router.ServeHTTP(w, req)
 }
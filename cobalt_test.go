@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -29,6 +30,103 @@ func NewRequest(method, path string, body io.Reader) *http.Request {
 	return r
 }
 
+// quiet returns a cobalt that doesn't log, running the middleware m for every
+// route.
+func quiet(m ...cobalt.MiddleWare) *cobalt.Cobalt {
+	c := cobalt.New(&JSONEncoder{})
+	c.DisableRequestLog = true
+	c.Logger = cobalt.NewStdLogger(log.New(io.Discard, "", 0))
+	c.Use(m...)
+	return c
+}
+
+// request returns a request with the headers of header, given as pairs of
+// names and values. Headers with an empty value are skipped, and a Host
+// header sets the host of the request.
+func request(method, path string, body io.Reader, header ...string) *http.Request {
+	r := NewRequest(method, path, body)
+	for i := 0; i+1 < len(header); i += 2 {
+		switch {
+		case header[i+1] == "":
+		case header[i] == "Host":
+			r.Host = header[i+1]
+		default:
+			r.Header.Set(header[i], header[i+1])
+		}
+	}
+	return r
+}
+
+// do serves r with c.
+func do(c *cobalt.Cobalt, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	return w
+}
+
+// get serves a GET request of path with c and the headers of header, given
+// as for request.
+func get(c *cobalt.Cobalt, path string, header ...string) *httptest.ResponseRecorder {
+	return do(c, request("GET", path, nil, header...))
+}
+
+// text returns the body of w without surrounding spaces.
+func text(w *httptest.ResponseRecorder) string {
+	return strings.TrimSpace(w.Body.String())
+}
+
+// cookieNamed returns the cookie name set by w, or nil.
+func cookieNamed(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, ck := range w.Result().Cookies() {
+		if ck.Name == name {
+			return ck
+		}
+	}
+	return nil
+}
+
+// client serves the requests of a browser with c, keeping the cookies set by
+// the responses.
+type client struct {
+	c       *cobalt.Cobalt
+	cookies map[string]*http.Cookie
+}
+
+// newClient returns a client of c without cookies.
+func newClient(c *cobalt.Cobalt) *client {
+	return &client{c: c, cookies: make(map[string]*http.Cookie)}
+}
+
+// do serves r with the cookies of the client.
+func (cl *client) do(r *http.Request) *httptest.ResponseRecorder {
+	for _, ck := range cl.cookies {
+		r.AddCookie(ck)
+	}
+
+	w := do(cl.c, r)
+	for _, ck := range w.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(cl.cookies, ck.Name)
+			continue
+		}
+		cl.cookies[ck.Name] = ck
+	}
+	return w
+}
+
+// get serves a GET request of path with the headers of header, given as for
+// request.
+func (cl *client) get(path string, header ...string) *httptest.ResponseRecorder {
+	return cl.do(request("GET", path, nil, header...))
+}
+
+// post serves a POST request of path with the form values.
+func (cl *client) post(path string, form url.Values, header ...string) *httptest.ResponseRecorder {
+	r := request("POST", path, strings.NewReader(form.Encode()), header...)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return cl.do(r)
+}
+
 // TestReqeust tests
 func TestRequest(t *testing.T) {
 	r := NewRequest("GET", "/", nil)
@@ -0,0 +1,401 @@
+package cobalt
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMinCompressSize is the smallest response compressed by default.
+const defaultMinCompressSize = 1024
+
+// defaultCompressTypes are the content types compressed by default.
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+type (
+	// CompressWriter is a writer compressing what is written to it. It is
+	// reset to be reused for other responses.
+	CompressWriter interface {
+		io.WriteCloser
+
+		// Flush writes any buffered data to the underlying writer.
+		Flush() error
+
+		// Reset discards the state of the writer and makes it write to w.
+		Reset(w io.Writer)
+	}
+
+	// Encoding is a content coding used by the Compress middleware.
+	Encoding struct {
+		// Name is the name of the coding in the Accept-Encoding and
+		// Content-Encoding headers, such as "gzip".
+		Name string
+
+		// NewWriter creates a writer compressing to w.
+		NewWriter func(w io.Writer) CompressWriter
+	}
+
+	// CompressOptions configures the Compress middleware.
+	CompressOptions struct {
+		// Encodings are the supported codings, in order of preference when
+		// the client accepts several equally. It defaults to gzip and then
+		// deflate at the default compression level.
+		Encodings []Encoding
+
+		// MinSize is the smallest response body compressed, in bytes. It
+		// defaults to 1024.
+		MinSize int
+
+		// ContentTypes are the media types compressed. An entry ending with
+		// "/*", such as "text/*", matches every subtype. It defaults to text,
+		// JSON, JavaScript, XML, WebAssembly and SVG types.
+		ContentTypes []string
+	}
+)
+
+// GzipEncoding returns the gzip Encoding at a compression level of the
+// compress/gzip package. It panics if level is invalid.
+func GzipEncoding(level int) Encoding {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		panic("cobalt: " + err.Error())
+	}
+	return Encoding{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) CompressWriter {
+			gz, _ := gzip.NewWriterLevel(w, level)
+			return gz
+		},
+	}
+}
+
+// DeflateEncoding returns the deflate Encoding at a compression level of the
+// compress/flate package. It panics if level is invalid.
+func DeflateEncoding(level int) Encoding {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		panic("cobalt: " + err.Error())
+	}
+	return Encoding{
+		Name: "deflate",
+		NewWriter: func(w io.Writer) CompressWriter {
+			fl, _ := flate.NewWriter(w, level)
+			return fl
+		},
+	}
+}
+
+// encoder is an Encoding with a pool of its writers.
+type encoder struct {
+	Encoding
+	pool sync.Pool
+}
+
+// get returns a writer compressing to w.
+func (e *encoder) get(w io.Writer) CompressWriter {
+	if cw, ok := e.pool.Get().(CompressWriter); ok {
+		cw.Reset(w)
+		return cw
+	}
+	return e.NewWriter(w)
+}
+
+// compressor holds the settings of a Compress middleware.
+type compressor struct {
+	encoders []*encoder
+	minSize  int
+	types    []string
+}
+
+// Compress returns middleware compressing responses with the coding
+// negotiated from the Accept-Encoding header of the request. Responses are
+// only compressed when they are at least MinSize bytes and of one of the
+// ContentTypes. Responses that already have a Content-Encoding, partial
+// content, responses to HEAD requests and streamed responses, which are
+// flushed before MinSize bytes are written, are sent unchanged.
+//
+// Compressed responses have Vary: Accept-Encoding set for caches and their
+// ETag made weak, since the compressed bytes differ from the ones the ETag was
+// computed from. Weak ETags still match in If-None-Match.
+//
+// Example
+//
+//	c.Use(cobalt.Compress())
+func Compress(options ...CompressOptions) MiddleWare {
+	var o CompressOptions
+	if len(options) > 0 {
+		o = options[0]
+	}
+
+	encodings := o.Encodings
+	if len(encodings) == 0 {
+		encodings = []Encoding{GzipEncoding(gzip.DefaultCompression), DeflateEncoding(flate.DefaultCompression)}
+	}
+
+	comp := compressor{minSize: o.MinSize, types: o.ContentTypes}
+	if comp.minSize == 0 {
+		comp.minSize = defaultMinCompressSize
+	}
+	if len(comp.types) == 0 {
+		comp.types = defaultCompressTypes
+	}
+	for _, e := range encodings {
+		comp.encoders = append(comp.encoders, &encoder{Encoding: e})
+	}
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			prev := ctx.Response
+			cw := compressWriter{
+				ResponseWriter: prev,
+				comp:           &comp,
+				enc:            comp.negotiate(ctx.Request.Header.Get("Accept-Encoding")),
+				head:           ctx.Request.Method == "HEAD",
+			}
+			ctx.Response = &cw
+
+			defer func() {
+				ctx.Response = prev
+				if p := recover(); p != nil {
+					// Nothing buffered is sent, so the panic can still be
+					// answered with an error.
+					panic(p)
+				}
+				cw.close()
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// negotiate returns the preferred encoder accepted by the Accept-Encoding
+// header, or nil if none is.
+func (comp *compressor) negotiate(accept string) *encoder {
+	if accept == "" {
+		return nil
+	}
+
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		q[name] = weight
+	}
+
+	var best *encoder
+	bestQ := 0.0
+	for _, e := range comp.encoders {
+		w, ok := q[strings.ToLower(e.Name)]
+		if !ok {
+			w = q["*"]
+		}
+		if w > bestQ {
+			best, bestQ = e, w
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the media type ct are compressed.
+func (comp *compressor) compressible(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range comp.types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mt, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(t, mt) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether to
+// compress it.
+type compressWriter struct {
+	http.ResponseWriter
+
+	comp *compressor
+	enc  *encoder
+	head bool
+
+	code    int
+	buf     []byte
+	decided bool
+	w       CompressWriter
+}
+
+// WriteHeader implements http.ResponseWriter. The header is sent once it is
+// known whether the response is compressed.
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.code != 0 {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	cw.code = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.code == 0 {
+			cw.code = http.StatusOK
+		}
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.comp.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.w != nil {
+		return cw.w.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher. Responses flushed before they are known to
+// be large enough are streamed uncompressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.w != nil {
+		cw.w.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for
+// http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sets the headers, sends them and writes the buffered body, either
+// compressed or not.
+func (cw *compressWriter) decide(streaming bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	eligible := h.Get("Content-Encoding") == "" && cw.comp.compressible(h.Get("Content-Type"))
+	if eligible {
+		addVary(h, "Accept-Encoding")
+	}
+
+	compress := eligible && !streaming && cw.enc != nil && !cw.head &&
+		len(cw.buf) >= cw.comp.minSize && h.Get("Content-Range") == "" &&
+		cw.code != http.StatusNoContent && cw.code != http.StatusNotModified
+
+	if compress {
+		h.Set("Content-Encoding", cw.enc.Name)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+
+	if cw.code != 0 {
+		cw.ResponseWriter.WriteHeader(cw.code)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if compress {
+		cw.w = cw.enc.get(cw.ResponseWriter)
+		_, err := cw.w.Write(buf)
+		return err
+	}
+	if len(buf) > 0 {
+		_, err := cw.ResponseWriter.Write(buf)
+		return err
+	}
+	return nil
+}
+
+// close finishes the response and returns the compressing writer to its
+// pool.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.w != nil {
+		cw.w.Close()
+		cw.enc.pool.Put(cw.w)
+		cw.w = nil
+	}
+}
+
+// addVary adds value to the Vary header unless it is listed already.
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "*" || strings.EqualFold(s, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
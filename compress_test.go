@@ -0,0 +1,175 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// payload is a JSON value large enough to be compressed.
+var payload = map[string]string{"data": strings.Repeat("cobalt ", 500)}
+
+// compressApp returns a cobalt compressing the responses of its routes.
+func compressApp(options ...cobalt.CompressOptions) *cobalt.Cobalt {
+	c := quiet(cobalt.Compress(options...))
+
+	c.Get("/json", func(ctx *cobalt.Context) {
+		ctx.Response.Header().Set("ETag", `"v1"`)
+		ctx.ServeCachedWithStatus(payload, http.StatusOK, 60)
+	})
+	c.Get("/small", func(ctx *cobalt.Context) {
+		ctx.Serve(map[string]string{"data": "small"})
+	})
+	c.Get("/image", func(ctx *cobalt.Context) {
+		ctx.ServeResponse(bytes.Repeat([]byte{0}, 4096), http.StatusOK, "image/png")
+	})
+	c.Get("/encoded", func(ctx *cobalt.Context) {
+		ctx.Response.Header().Set("Content-Encoding", "gzip")
+		ctx.ServeResponse(bytes.Repeat([]byte("a"), 4096), http.StatusOK, "text/plain")
+	})
+	c.Get("/stream", func(ctx *cobalt.Context) {
+		ctx.Response.Header().Set("Content-Type", "text/event-stream")
+		ctx.Response.Write([]byte("data: 1\n\n"))
+		ctx.Response.(http.Flusher).Flush()
+		ctx.Response.Write(bytes.Repeat([]byte("data: 2\n\n"), 500))
+	})
+	return c
+}
+
+// TestCompressJSON tests large JSON responses are compressed with gzip and
+// keep their caching headers.
+func TestCompressJSON(t *testing.T) {
+	w := get(compressApp(), "/json", "Accept-Encoding", "gzip, deflate")
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "gzip" || h.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected gzip with Vary, got %v", h)
+	}
+	if h.Get("Content-Length") != "" || h.Get("ETag") != `W/"v1"` {
+		t.Errorf("expected no length and a weak ETag, got %v", h)
+	}
+	if !strings.Contains(h.Get("Cache-control"), "max-age=60") || !strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		t.Errorf("expected the cache and content type headers to be kept, got %v", h)
+	}
+
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("expected gzip body, got %v", err)
+	}
+	b, _ := io.ReadAll(zr)
+
+	var buf bytes.Buffer
+	(&JSONEncoder{}).Encode(&buf, payload)
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Error("expected the body to decompress to the JSON payload")
+	}
+}
+
+// TestCompressNegotiation tests the coding is chosen by q-values and server
+// preference.
+func TestCompressNegotiation(t *testing.T) {
+	tests := []struct {
+		accept, encoding string
+	}{
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, *;q=0.1", "deflate"},
+		{"*", "gzip"},
+		{"br", ""},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	c := compressApp()
+	for _, tt := range tests {
+		w := get(c, "/json", "Accept-Encoding", tt.accept)
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%q: expected encoding %q, got %q", tt.accept, tt.encoding, got)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: expected Vary for a compressible type", tt.accept)
+		}
+		if tt.encoding == "deflate" {
+			b, _ := io.ReadAll(flate.NewReader(w.Body))
+			if !bytes.HasPrefix(b, []byte(`{"data":"cobalt`)) {
+				t.Errorf("%q: expected deflate body, got %q", tt.accept, b)
+			}
+		}
+	}
+}
+
+// TestCompressSkipped tests responses sent unchanged.
+func TestCompressSkipped(t *testing.T) {
+	tests := []struct {
+		path, vary string
+	}{
+		{"/small", "Accept-Encoding"},
+		{"/image", ""},
+		{"/encoded", ""},
+		{"/stream", "Accept-Encoding"},
+	}
+
+	c := compressApp()
+	for _, tt := range tests {
+		w := get(c, tt.path, "Accept-Encoding", "gzip")
+		if w.Header().Get("Vary") != tt.vary {
+			t.Errorf("%s: expected Vary %q, got %q", tt.path, tt.vary, w.Header().Get("Vary"))
+		}
+		if tt.path != "/encoded" && w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected no compression", tt.path)
+		}
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s: expected the body, got %d", tt.path, w.Code)
+		}
+	}
+
+	r := NewRequest("HEAD", "/json", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	c.Head("/json", func(ctx *cobalt.Context) {
+		ctx.ServeCachedWithStatus(payload, http.StatusOK, 60)
+	})
+	w := do(c, r)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("expected HEAD responses not to be compressed")
+	}
+}
+
+// upperWriter is a CompressWriter for a test coding upper casing the body.
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u *upperWriter) Write(p []byte) (int, error) { return u.w.Write(bytes.ToUpper(p)) }
+func (u *upperWriter) Flush() error                { return nil }
+func (u *upperWriter) Close() error                { return nil }
+func (u *upperWriter) Reset(w io.Writer)           { u.w = w }
+
+// TestCompressOptions tests custom encodings, sizes and content types.
+func TestCompressOptions(t *testing.T) {
+	c := compressApp(cobalt.CompressOptions{
+		Encodings: []cobalt.Encoding{{
+			Name:      "x-upper",
+			NewWriter: func(w io.Writer) cobalt.CompressWriter { return &upperWriter{w: w} },
+		}},
+		MinSize:      10,
+		ContentTypes: []string{"application/json"},
+	})
+
+	for i := 0; i < 2; i++ {
+		w := get(c, "/small", "Accept-Encoding", "x-upper, gzip")
+		if w.Header().Get("Content-Encoding") != "x-upper" || w.Body.String() != "{\"DATA\":\"SMALL\"}\n" {
+			t.Errorf("expected custom encoding, got %v %q", w.Header(), w.Body.String())
+		}
+	}
+
+	if w := get(c, "/stream", "Accept-Encoding", "x-upper"); w.Header().Get("Vary") != "" {
+		t.Error("expected types outside the allow-list to be left alone")
+	}
+}
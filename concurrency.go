@@ -0,0 +1,308 @@
+package cobalt
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the ConcurrencyLimiter.
+const (
+	defaultQueueTimeout = time.Second
+	defaultShedRetry    = time.Second
+	defaultLatency      = 100 * time.Millisecond
+	defaultBackoff      = 0.9
+)
+
+// Priority is the class of a request for admission by a ConcurrencyLimiter.
+type Priority int
+
+// Priority classes.
+const (
+	// PriorityNormal requests wait in the queue when the limit is reached.
+	PriorityNormal Priority = iota
+
+	// PriorityHigh requests are admitted from the queue before normal ones.
+	PriorityHigh
+
+	// PriorityLow requests are shed when the limit is reached instead of
+	// waiting.
+	PriorityLow
+
+	// PriorityBypass requests, such as health checks and admin requests,
+	// are never limited.
+	PriorityBypass
+)
+
+type (
+	// ConcurrencyOptions configures a ConcurrencyLimiter.
+	ConcurrencyOptions struct {
+		// Limit is the most requests served at once. It is the initial limit
+		// in adaptive mode.
+		Limit int
+
+		// QueueSize is the most requests waiting for a slot. Requests are
+		// shed when the queue is full. It defaults to no queue.
+		QueueSize int
+
+		// QueueTimeout is the longest a request waits in the queue. It
+		// defaults to 1 second.
+		QueueTimeout time.Duration
+
+		// Priority returns the class of a request. All requests are
+		// PriorityNormal by default.
+		Priority func(ctx *Context) Priority
+
+		// Adaptive adjusts the limit to the observed latency: it grows by
+		// one per limit's worth of requests completed within LatencyTarget
+		// while the limit is in use and shrinks by Backoff on requests slower
+		// than that, at most once per limit's worth of completed requests,
+		// so requests are shed before latency collapses.
+		Adaptive bool
+
+		// MinLimit and MaxLimit bound the adaptive limit. They default to 1
+		// and 10 times Limit.
+		MinLimit int
+		MaxLimit int
+
+		// LatencyTarget is the latency the adaptive limit aims to keep
+		// requests under. It defaults to 100 milliseconds.
+		LatencyTarget time.Duration
+
+		// Backoff is the factor the adaptive limit is multiplied by on slow
+		// requests. It defaults to 0.9.
+		Backoff float64
+
+		// RetryAfter is sent in the Retry-After header of shed requests. It
+		// defaults to 1 second.
+		RetryAfter time.Duration
+
+		// Body is encoded with the Coder and served with a 503 status to
+		// shed requests. It defaults to a message saying the server is
+		// overloaded.
+		Body interface{}
+
+		// OnShed is called after a shed request is answered, for example to
+		// report it to metrics.
+		OnShed func(ctx *Context)
+	}
+
+	// ConcurrencyStats is a snapshot of a ConcurrencyLimiter.
+	ConcurrencyStats struct {
+		Limit    int
+		InFlight int
+		Queued   int
+	}
+
+	// ConcurrencyLimiter limits the number of requests served at once.
+	ConcurrencyLimiter struct {
+		o ConcurrencyOptions
+
+		mu       sync.Mutex
+		limit    float64
+		inFlight int
+		high     []*waiter
+		normal   []*waiter
+
+		// completed counts the completed requests, and backoff is the count
+		// from which the adaptive limit may shrink again.
+		completed int
+		backoff   int
+	}
+
+	// waiter is a request waiting in the queue.
+	waiter struct {
+		ready   chan struct{}
+		granted bool
+	}
+)
+
+// shedBody is the default body served to shed requests.
+type shedBody struct {
+	Error string
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter. Each limiter has its own
+// limit, so one limiter added with Use caps the requests of the whole server
+// while limiters added to routes or groups cap those separately.
+func NewConcurrencyLimiter(o ConcurrencyOptions) *ConcurrencyLimiter {
+	if o.Limit < 1 {
+		o.Limit = 1
+	}
+	if o.QueueTimeout == 0 {
+		o.QueueTimeout = defaultQueueTimeout
+	}
+	if o.MinLimit < 1 {
+		o.MinLimit = 1
+	}
+	if o.MaxLimit == 0 {
+		o.MaxLimit = 10 * o.Limit
+	}
+	if o.LatencyTarget == 0 {
+		o.LatencyTarget = defaultLatency
+	}
+	if o.Backoff == 0 {
+		o.Backoff = defaultBackoff
+	}
+	if o.RetryAfter == 0 {
+		o.RetryAfter = defaultShedRetry
+	}
+	if o.Body == nil {
+		o.Body = shedBody{Error: "server overloaded"}
+	}
+
+	return &ConcurrencyLimiter{o: o, limit: float64(o.Limit)}
+}
+
+// Stats returns the current limit, the requests in flight and the requests
+// waiting in the queue.
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return ConcurrencyStats{Limit: int(l.limit), InFlight: l.inFlight, Queued: len(l.high) + len(l.normal)}
+}
+
+// Concurrency returns middleware admitting requests through l. Requests that
+// can't be admitted are shed with 503 Service Unavailable and a Retry-After
+// header.
+//
+// Example
+//
+//	limiter := cobalt.NewConcurrencyLimiter(cobalt.ConcurrencyOptions{
+//		Limit:     100,
+//		QueueSize: 200,
+//		Adaptive:  true,
+//		Priority: func(ctx *cobalt.Context) cobalt.Priority {
+//			if ctx.Route() == "/health" {
+//				return cobalt.PriorityBypass
+//			}
+//			return cobalt.PriorityNormal
+//		},
+//	})
+//	c.Use(cobalt.Concurrency(limiter))
+func Concurrency(l *ConcurrencyLimiter) MiddleWare {
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			p := PriorityNormal
+			if l.o.Priority != nil {
+				p = l.o.Priority(ctx)
+			}
+			if p == PriorityBypass {
+				h(ctx)
+				return
+			}
+
+			if !l.acquire(ctx.Request.Context(), p) {
+				ctx.Response.Header().Set(retryAfterHeader, strconv.Itoa(seconds(l.o.RetryAfter)))
+				ctx.ServeWithStatus(l.o.Body, http.StatusServiceUnavailable)
+				if l.o.OnShed != nil {
+					l.o.OnShed(ctx)
+				}
+				return
+			}
+
+			st := time.Now()
+			defer func() {
+				l.release(time.Since(st))
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// acquire admits a request, waiting in the queue if there is room. It
+// returns false if the request is shed.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, p Priority) bool {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if p == PriorityLow || len(l.high)+len(l.normal) >= l.o.QueueSize {
+		l.mu.Unlock()
+		return false
+	}
+
+	w := waiter{ready: make(chan struct{})}
+	if p == PriorityHigh {
+		l.high = append(l.high, &w)
+	} else {
+		l.normal = append(l.normal, &w)
+	}
+	l.mu.Unlock()
+
+	t := time.NewTimer(l.o.QueueTimeout)
+	defer t.Stop()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The slot may have been granted while giving up.
+	if w.granted {
+		return true
+	}
+	l.high = removeWaiter(l.high, &w)
+	l.normal = removeWaiter(l.normal, &w)
+	return false
+}
+
+// release frees the slot of a request that took latency and admits waiting
+// requests while there are free slots.
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.o.Adaptive {
+		// The requests admitted under the previous limit complete slowly
+		// too, so the limit only shrinks again once they are done.
+		l.completed++
+		if latency > l.o.LatencyTarget {
+			if l.completed >= l.backoff {
+				l.limit = math.Max(float64(l.o.MinLimit), l.limit*l.o.Backoff)
+				l.backoff = l.completed + int(l.limit)
+			}
+		} else if float64(l.inFlight) >= l.limit/2 {
+			l.limit = math.Min(float64(l.o.MaxLimit), l.limit+1/l.limit)
+		}
+	}
+	l.inFlight--
+
+	for l.inFlight < int(l.limit) {
+		var w *waiter
+		switch {
+		case len(l.high) > 0:
+			w, l.high = l.high[0], l.high[1:]
+		case len(l.normal) > 0:
+			w, l.normal = l.normal[0], l.normal[1:]
+		default:
+			return
+		}
+
+		w.granted = true
+		close(w.ready)
+		l.inFlight++
+	}
+}
+
+// removeWaiter removes w from the queue q.
+func removeWaiter(q []*waiter, w *waiter) []*waiter {
+	for i := range q {
+		if q[i] == w {
+			return append(q[:i], q[i+1:]...)
+		}
+	}
+	return q
+}
@@ -0,0 +1,185 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// blockingApp returns a cobalt admitting requests through l. Its /block
+// route waits on release and its /fast route doesn't.
+func blockingApp(l *cobalt.ConcurrencyLimiter, release chan struct{}) *cobalt.Cobalt {
+	c := quiet()
+	c.Use(cobalt.Concurrency(l))
+	c.Get("/block/:priority", func(ctx *cobalt.Context) {
+		<-release
+		ctx.ServeStatus(http.StatusOK)
+	})
+	c.Get("/fast/:priority", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+	return c
+}
+
+// priority classifies requests by the last element of their path.
+func priority(ctx *cobalt.Context) cobalt.Priority {
+	switch {
+	case strings.HasSuffix(ctx.Request.URL.Path, "/high"):
+		return cobalt.PriorityHigh
+	case strings.HasSuffix(ctx.Request.URL.Path, "/low"):
+		return cobalt.PriorityLow
+	case strings.HasSuffix(ctx.Request.URL.Path, "/bypass"):
+		return cobalt.PriorityBypass
+	}
+	return cobalt.PriorityNormal
+}
+
+// serve serves a request in the background, sending the recorder on done.
+func serve(c *cobalt.Cobalt, path string, done chan<- *httptest.ResponseRecorder) {
+	go func() {
+		done <- get(c, path)
+	}()
+}
+
+// waitStats waits until the limiter has the number of requests in flight and
+// queued.
+func waitStats(t *testing.T, l *cobalt.ConcurrencyLimiter, inFlight, queued int) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if s := l.Stats(); s.InFlight == inFlight && s.Queued == queued {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d in flight and %d queued, got %+v", inFlight, queued, l.Stats())
+}
+
+// TestConcurrencyQueue tests requests wait in a bounded queue, by priority,
+// and are shed when it is full.
+func TestConcurrencyQueue(t *testing.T) {
+	shed := 0
+	l := cobalt.NewConcurrencyLimiter(cobalt.ConcurrencyOptions{
+		Limit:      1,
+		QueueSize:  2,
+		Priority:   priority,
+		RetryAfter: 3 * time.Second,
+		OnShed:     func(ctx *cobalt.Context) { shed++ },
+	})
+	release := make(chan struct{})
+	c := blockingApp(l, release)
+
+	first := make(chan *httptest.ResponseRecorder, 1)
+	normal := make(chan *httptest.ResponseRecorder, 1)
+	high := make(chan *httptest.ResponseRecorder, 1)
+
+	serve(c, "/block/normal", first)
+	waitStats(t, l, 1, 0)
+	serve(c, "/block/normal", normal)
+	waitStats(t, l, 1, 1)
+	serve(c, "/block/high", high)
+	waitStats(t, l, 1, 2)
+
+	// The queue is full.
+	w := get(c, "/block/normal")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" || !strings.Contains(w.Body.String(), "overloaded") {
+		t.Errorf("expected 503 with Retry-After, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if shed != 1 {
+		t.Errorf("expected OnShed to be called, got %d", shed)
+	}
+
+	// Bypass requests are always served.
+	w = get(c, "/fast/bypass")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected bypass request to be served, got %d", w.Code)
+	}
+
+	// The high priority request is admitted before the normal one.
+	release <- struct{}{}
+	<-first
+	waitStats(t, l, 1, 1)
+	release <- struct{}{}
+	<-high
+	release <- struct{}{}
+	<-normal
+	waitStats(t, l, 0, 0)
+}
+
+// TestConcurrencyShed tests low priority requests are shed and queued
+// requests time out.
+func TestConcurrencyShed(t *testing.T) {
+	l := cobalt.NewConcurrencyLimiter(cobalt.ConcurrencyOptions{
+		Limit:        1,
+		QueueSize:    10,
+		QueueTimeout: 20 * time.Millisecond,
+		Priority:     priority,
+	})
+	release := make(chan struct{})
+	c := blockingApp(l, release)
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	serve(c, "/block/normal", done)
+	waitStats(t, l, 1, 0)
+
+	for _, path := range []string{"/block/low", "/block/normal"} {
+		w := get(c, path)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected to be shed, got %d", path, w.Code)
+		}
+	}
+	waitStats(t, l, 1, 0)
+
+	release <- struct{}{}
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("expected the first request to be served, got %d", w.Code)
+	}
+}
+
+// TestConcurrencyAdaptive tests the limit shrinks once per limit's worth of
+// slow requests and grows again when they are fast.
+func TestConcurrencyAdaptive(t *testing.T) {
+	l := cobalt.NewConcurrencyLimiter(cobalt.ConcurrencyOptions{
+		Limit:         10,
+		MinLimit:      1,
+		Adaptive:      true,
+		LatencyTarget: 5 * time.Millisecond,
+		Backoff:       0.5,
+	})
+
+	slow := true
+	c := quiet()
+	c.Use(cobalt.Concurrency(l))
+	c.Get("/", func(ctx *cobalt.Context) {
+		if slow {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	get(c, "/")
+	for i := 0; i < 4; i++ {
+		get(c, "/")
+		if s := l.Stats(); s.Limit != 5 {
+			t.Fatalf("expected the limit to shrink once until 5 requests completed, got %d", s.Limit)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		get(c, "/")
+	}
+	if s := l.Stats(); s.Limit != 1 {
+		t.Errorf("expected the limit to shrink to the minimum, got %d", s.Limit)
+	}
+
+	// One request at a time only uses the limit while it is below two, so
+	// it grows no further.
+	slow = false
+	for i := 0; i < 10; i++ {
+		get(c, "/")
+	}
+	if s := l.Stats(); s.Limit != 2 {
+		t.Errorf("expected the limit to grow while in use, got %d", s.Limit)
+	}
+}
@@ -2,13 +2,13 @@ package cobalt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/pborman/uuid"
 )
 
 const (
@@ -32,21 +32,71 @@ type (
 		params    httprouter.Params
 		coder     Coder
 		templates Templates
+		route     string
+		span      *Span
+		writer    ResponseWriter
+		logger    Logger
+		reqLogger Logger
+
+		// cors is the CORS middleware recorded for routes, the one closest
+		// to the route.
+		cors *corsHandler
+
+		// proxy configures the proxies trusted to report the client, which
+		// is resolved the first time it is needed.
+		proxy    ProxyOptions
+		resolved *client
+
+		// cookies configures the cookies set by the handlers.
+		cookies CookieOptions
+
+		// claims are the claims of the token the request was
+		// authenticated with.
+		claims *Claims
+
+		// identity is who the request was authenticated as.
+		identity *Identity
+
+		// policies are the policies required by the middleware of the
+		// route, checked before the handler runs.
+		policies []Policy
+
+		// session is the session of the request, loaded by sessions when
+		// first used.
+		session  *Session
+		sessions *sessionManager
+
+		// csrf verifies the requests of the route and provides the tokens of
+		// its pages, unless the route is exempt.
+		csrf       *csrfManager
+		csrfExempt bool
+		csrfSecret []byte
+
+		// flashes are the flash messages read and added by the request.
+		flashes flashes
 	}
 )
 
 // NewContext creates a new context instance with a http.Request and http.ResponseWriter.
+// The http.ResponseWriter is wrapped in a ResponseWriter and the request ID is
+// assigned using the default RequestIDOptions.
 func NewContext(req *http.Request, resp http.ResponseWriter, p httprouter.Params, coder Coder, templates Templates) *Context {
+	return newContext(req, resp, p, coder, templates, RequestIDOptions{})
+}
 
-	id := req.Header.Get(idHeader)
-	if id == "" {
-		id = uuid.New()
-	}
+// newContext creates a new context instance assigning the request ID with ids.
+// The ID is stored in the context of the request so it can be propagated.
+func newContext(req *http.Request, resp http.ResponseWriter, p httprouter.Params, coder Coder, templates Templates, ids RequestIDOptions) *Context {
+	id := ids.requestID(req)
+	req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+
+	rw := newResponseWriter(resp)
 
 	return &Context{
 		ID:        id,
 		Request:   req,
-		Response:  resp,
+		Response:  rw,
+		writer:    rw,
 		data:      make(map[string]interface{}),
 		params:    p,
 		coder:     coder,
@@ -54,9 +104,29 @@ func NewContext(req *http.Request, resp http.ResponseWriter, p httprouter.Params
 	}
 }
 
-// ParamValue returns the value for the associated key from the url parameters.
-func (c *Context) ParamValue(key string) string {
-	return c.params.ByName(key)
+// Route returns the pattern of the route that matched the request, such as
+// "/users/:id", or "" when no route matched.
+func (c *Context) Route() string {
+	return c.route
+}
+
+// Writer returns the ResponseWriter recording the response sent to the
+// client.
+func (c *Context) Writer() ResponseWriter {
+	return c.writer
+}
+
+// Protocol returns the protocol negotiated for the request: "h2" for HTTP/2
+// over TLS, "h2c" for HTTP/2 over cleartext and otherwise the HTTP/1 version
+// such as "http/1.1".
+func (c *Context) Protocol() string {
+	if c.Request.ProtoMajor == 2 {
+		if c.Request.TLS == nil {
+			return "h2c"
+		}
+		return "h2"
+	}
+	return strings.ToLower(c.Request.Proto)
 }
 
 // GetData returns the value for the specified key from the context data. Usually used by prefilters to pass data to the http handler
@@ -89,7 +159,9 @@ func (c *Context) DecodeBody(val interface{}) error {
 	return c.coder.Decode(c.Request.Body, val)
 }
 
-// Redirect is a helper to redirect the user to a new url
+// Redirect is a helper to redirect the user to a new url. Relative URLs are
+// sent as paths, so they stay on the host the client requested; use
+// AbsoluteURL to redirect to the public URL reported by trusted proxies.
 func (c *Context) Redirect(url string, status int) {
 	http.Redirect(c.Response, c.Request, url, status)
 	c.Status = status
@@ -175,16 +247,14 @@ func (c *Context) ServeHTML(page string, data interface{}, options ...HTMLOption
 		op.Status = http.StatusOK
 	}
 
-	// execute is the func we'll call to execute the template. If they set the
-	// NoLayout flag then we switch out to the ExecuteOnly func which has the
-	// same signature as Execute
-	execute := c.templates.Execute
+	// The layout is skipped if they set the NoLayout flag, like ExecuteOnly.
+	layout := c.templates.Layout
 	if op.NoLayout {
-		execute = c.templates.ExecuteOnly
+		layout = ""
 	}
 
-	if err := execute(&buf, page, data); err != nil {
-		log.Printf("%s error in template: %v", c.ID, err)
+	if err := c.templates.execute(&buf, layout, page, data, c.templateFuncs()); err != nil {
+		c.Logger().Error("template error", Field{"page", page}, Field{"error", err})
 		c.ServeResponse([]byte("Error in template"), http.StatusInternalServerError, "text/plain")
 		return
 	}
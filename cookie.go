@@ -0,0 +1,317 @@
+package cobalt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultMaxCookieSize is the largest cookie most browsers store, including
+// its name and attributes.
+const defaultMaxCookieSize = 4096
+
+var (
+	// ErrInvalidCookie is returned for signed or encrypted cookies that fail
+	// verification, such as cookies modified by the client or signed with a
+	// key that was removed.
+	ErrInvalidCookie = errors.New("cobalt: invalid cookie")
+
+	// ErrCookieTooLarge is returned for cookies larger than the MaxSize of
+	// their CookieOptions.
+	ErrCookieTooLarge = errors.New("cobalt: cookie too large")
+)
+
+// CookieOptions configures the cookies set by Context. The defaults are
+// secure: cookies are HttpOnly, Secure and SameSite=Lax.
+type CookieOptions struct {
+	// Keys sign the values of signed cookies with HMAC-SHA256. The first key
+	// signs new cookies and all of them verify cookies, so keys can be
+	// rotated by adding a new key first and removing old keys once their
+	// cookies expired. Keys should be 32 random bytes.
+	Keys [][]byte
+
+	// EncryptionKeys encrypt the values of encrypted cookies with AES-GCM,
+	// rotated like Keys. Keys must be 16, 24 or 32 bytes.
+	EncryptionKeys [][]byte
+
+	// Path and Domain scope cookies. Path defaults to "/".
+	Path   string
+	Domain string
+
+	// MaxAge is how long cookies are kept by the client. Cookies are
+	// deleted when the browser closes if it is zero.
+	MaxAge time.Duration
+
+	// Insecure lets cookies be sent over plain HTTP, for development.
+	Insecure bool
+
+	// Script lets scripts of the page read cookies, which are HttpOnly
+	// otherwise.
+	Script bool
+
+	// SameSite is the SameSite attribute of cookies. It defaults to Lax.
+	SameSite http.SameSite
+
+	// MaxSize is the largest cookie that can be set, in bytes. It defaults
+	// to 4096.
+	MaxSize int
+}
+
+// cookieOptions returns the options of a cookie: options if given, otherwise
+// Cobalt.Cookies, with the defaults applied. Options without keys use the
+// keys of Cobalt.Cookies.
+func (c *Context) cookieOptions(options []CookieOptions) CookieOptions {
+	o := c.cookies
+	if len(options) > 0 {
+		o = options[0]
+		if o.Keys == nil {
+			o.Keys = c.cookies.Keys
+		}
+		if o.EncryptionKeys == nil {
+			o.EncryptionKeys = c.cookies.EncryptionKeys
+		}
+	}
+
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = defaultMaxCookieSize
+	}
+	return o
+}
+
+// SetCookie sets the cookie name to value with the attributes of
+// Cobalt.Cookies, or of options if given. It returns ErrCookieTooLarge if the
+// cookie is larger than the MaxSize of the options.
+//
+// Example
+//
+//	ctx.SetCookie("theme", "dark", cobalt.CookieOptions{MaxAge: 365 * 24 * time.Hour})
+func (c *Context) SetCookie(name, value string, options ...CookieOptions) error {
+	o := c.cookieOptions(options)
+
+	ck := http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		Secure:   !o.Insecure,
+		HttpOnly: !o.Script,
+		SameSite: o.SameSite,
+	}
+	if o.MaxAge > 0 {
+		ck.MaxAge = int(o.MaxAge / time.Second)
+		ck.Expires = time.Now().Add(o.MaxAge).UTC()
+	}
+
+	v := ck.String()
+	if len(v) > o.MaxSize {
+		return ErrCookieTooLarge
+	}
+	c.Response.Header().Add("Set-Cookie", v)
+	return nil
+}
+
+// Cookie returns the value of the cookie name of the request, or
+// http.ErrNoCookie if there is none.
+func (c *Context) Cookie(name string) (string, error) {
+	ck, err := c.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return ck.Value, nil
+}
+
+// DeleteCookie deletes the cookie name. The Path and Domain of options must
+// be the ones the cookie was set with.
+func (c *Context) DeleteCookie(name string, options ...CookieOptions) {
+	o := c.cookieOptions(options)
+	http.SetCookie(c.Response, &http.Cookie{
+		Name:     name,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   -1,
+		Secure:   !o.Insecure,
+		HttpOnly: !o.Script,
+		SameSite: o.SameSite,
+	})
+}
+
+// SetSignedCookie sets the cookie name to val encoded with the Coder and
+// signed with the first of the Keys of the options. The client can read the
+// value but not change it.
+//
+// Example
+//
+//	ctx.SetSignedCookie("prefs", Prefs{Lang: "en"})
+func (c *Context) SetSignedCookie(name string, val interface{}, options ...CookieOptions) error {
+	o := c.cookieOptions(options)
+	if len(o.Keys) == 0 {
+		panic("cobalt: signed cookies require Keys in CookieOptions")
+	}
+	return c.setCookie(name, val, o.Keys, nil, o)
+}
+
+// SignedCookie decodes the value of the signed cookie name into val, a
+// pointer. It returns http.ErrNoCookie if there is no cookie and
+// ErrInvalidCookie if its signature isn't valid.
+func (c *Context) SignedCookie(name string, val interface{}, options ...CookieOptions) error {
+	o := c.cookieOptions(options)
+	if len(o.Keys) == 0 {
+		panic("cobalt: signed cookies require Keys in CookieOptions")
+	}
+	return c.cookie(name, val, o.Keys, nil)
+}
+
+// SetEncryptedCookie sets the cookie name to val encoded with the Coder and
+// encrypted with the first of the EncryptionKeys of the options. The client
+// can neither read nor change the value.
+func (c *Context) SetEncryptedCookie(name string, val interface{}, options ...CookieOptions) error {
+	o := c.cookieOptions(options)
+	if len(o.EncryptionKeys) == 0 {
+		panic("cobalt: encrypted cookies require EncryptionKeys in CookieOptions")
+	}
+	return c.setCookie(name, val, nil, o.EncryptionKeys, o)
+}
+
+// EncryptedCookie decodes the value of the encrypted cookie name into val, a
+// pointer. It returns http.ErrNoCookie if there is no cookie and
+// ErrInvalidCookie if it can't be decrypted.
+func (c *Context) EncryptedCookie(name string, val interface{}, options ...CookieOptions) error {
+	o := c.cookieOptions(options)
+	if len(o.EncryptionKeys) == 0 {
+		panic("cobalt: encrypted cookies require EncryptionKeys in CookieOptions")
+	}
+	return c.cookie(name, val, nil, o.EncryptionKeys)
+}
+
+// setCookie sets the cookie name to val encoded with the Coder, signed with
+// hashKeys or encrypted with blockKeys.
+func (c *Context) setCookie(name string, val interface{}, hashKeys, blockKeys [][]byte, o CookieOptions) error {
+	var b bytes.Buffer
+	if err := c.coder.Encode(&b, val); err != nil {
+		return err
+	}
+	value, err := encodeCookie(hashKeys, blockKeys, name, b.Bytes())
+	if err != nil {
+		return err
+	}
+	return c.SetCookie(name, value, o)
+}
+
+// cookie decodes the cookie name, signed with hashKeys or encrypted with
+// blockKeys, into val.
+func (c *Context) cookie(name string, val interface{}, hashKeys, blockKeys [][]byte) error {
+	value, err := c.Cookie(name)
+	if err != nil {
+		return err
+	}
+	b, err := decodeCookie(hashKeys, blockKeys, name, value)
+	if err != nil {
+		return err
+	}
+	if err := c.coder.Decode(bytes.NewReader(b), val); err != nil {
+		return ErrInvalidCookie
+	}
+	return nil
+}
+
+// encodeCookie encodes the value of the cookie name, encrypted with the first
+// of blockKeys and signed with the first of hashKeys if there are any. The
+// name is authenticated with the value so values can't be moved to other
+// cookies.
+func encodeCookie(hashKeys, blockKeys [][]byte, name string, value []byte) (string, error) {
+	if len(blockKeys) > 0 {
+		aead, err := newAEAD(blockKeys[0])
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		value = aead.Seal(nonce, nonce, value, []byte(name))
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(value)
+	if len(hashKeys) == 0 {
+		return payload, nil
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cookieMAC(hashKeys[0], name, payload)), nil
+}
+
+// decodeCookie verifies and decodes a value encoded with encodeCookie, trying
+// every key.
+func decodeCookie(hashKeys, blockKeys [][]byte, name, encoded string) ([]byte, error) {
+	payload := encoded
+	if len(hashKeys) > 0 {
+		var sig string
+		var ok bool
+		if payload, sig, ok = strings.Cut(encoded, "."); !ok {
+			return nil, ErrInvalidCookie
+		}
+		mac, err := base64.RawURLEncoding.DecodeString(sig)
+		if err != nil {
+			return nil, ErrInvalidCookie
+		}
+
+		verified := false
+		for _, k := range hashKeys {
+			if hmac.Equal(mac, cookieMAC(k, name, payload)) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, ErrInvalidCookie
+		}
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	if len(blockKeys) == 0 {
+		return value, nil
+	}
+
+	for _, k := range blockKeys {
+		aead, err := newAEAD(k)
+		if err != nil || len(value) < aead.NonceSize() {
+			continue
+		}
+		nonce, ct := value[:aead.NonceSize()], value[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ct, []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+// newAEAD returns AES-GCM with key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cookieMAC returns the signature of the payload of the cookie name.
+func cookieMAC(key []byte, name, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,160 @@
+package cobalt_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// prefs is a value stored in cookies.
+type prefs struct {
+	Lang string `json:"lang"`
+	Size int    `json:"size"`
+}
+
+// cookieApp returns a cobalt setting cookies at /set and serving their values
+// at /get.
+func cookieApp(o cobalt.CookieOptions) *cobalt.Cobalt {
+	c := quiet()
+	c.Cookies = o
+	c.Get("/set", func(ctx *cobalt.Context) {
+		if err := ctx.SetCookie("theme", "dark"); err != nil {
+			ctx.ServeWithStatus(err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := ctx.SetSignedCookie("prefs", prefs{Lang: "en", Size: 12}); err != nil {
+			ctx.ServeWithStatus(err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := ctx.SetEncryptedCookie("secret", "s3cret"); err != nil {
+			ctx.ServeWithStatus(err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Get("/get", func(ctx *cobalt.Context) {
+		var p prefs
+		var secret string
+		theme, _ := ctx.Cookie("theme")
+		perr := ctx.SignedCookie("prefs", &p)
+		serr := ctx.EncryptedCookie("secret", &secret)
+		ctx.Serve(map[string]interface{}{"theme": theme, "prefs": p, "secret": secret, "prefs_ok": perr == nil, "secret_ok": serr == nil})
+	})
+	c.Get("/big", func(ctx *cobalt.Context) {
+		err := ctx.SetCookie("big", strings.Repeat("x", 5000))
+		ctx.ServeWithStatus(errors.Is(err, cobalt.ErrCookieTooLarge), http.StatusOK)
+	})
+	return c
+}
+
+// roundTrip sets the cookies of set and serves /get of get with them.
+func roundTrip(t *testing.T, set, get *cobalt.Cobalt, tamper func(*http.Cookie)) string {
+	t.Helper()
+	cl := newClient(set)
+	if w := cl.get("/set"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the cookies to be set, got %d %s", w.Code, w.Body.String())
+	}
+	if tamper != nil {
+		for _, ck := range cl.cookies {
+			tamper(ck)
+		}
+	}
+	cl.c = get
+	return text(cl.get("/get"))
+}
+
+// TestCookies tests cookies are set with secure defaults and signed and
+// encrypted values are decoded.
+func TestCookies(t *testing.T) {
+	o := cobalt.CookieOptions{
+		Keys:           [][]byte{[]byte("sign-1")},
+		EncryptionKeys: [][]byte{[]byte("0123456789abcdef")},
+	}
+	c := cookieApp(o)
+
+	w := get(c, "/set")
+	for _, ck := range w.Result().Cookies() {
+		if !ck.HttpOnly || !ck.Secure || ck.SameSite != http.SameSiteLaxMode || ck.Path != "/" {
+			t.Errorf("expected secure defaults, got %s", ck)
+		}
+		if ck.Name == "secret" && strings.Contains(ck.Value, "s3cret") {
+			t.Errorf("expected the value to be encrypted, got %s", ck.Value)
+		}
+	}
+
+	expected := `{"prefs":{"lang":"en","size":12},"prefs_ok":true,"secret":"s3cret","secret_ok":true,"theme":"dark"}`
+	if body := roundTrip(t, c, c, nil); body != expected {
+		t.Errorf("expected the values of the cookies, got %s", body)
+	}
+
+	tampered := roundTrip(t, c, c, func(ck *http.Cookie) {
+		if ck.Name != "theme" {
+			first := "A"
+			if ck.Value[0] == 'A' {
+				first = "B"
+			}
+			ck.Value = first + ck.Value[1:]
+		}
+	})
+	if !strings.Contains(tampered, `"prefs_ok":false`) || !strings.Contains(tampered, `"secret_ok":false`) {
+		t.Errorf("expected tampered cookies to be rejected, got %s", tampered)
+	}
+
+	if body := text(get(c, "/big")); body != "true" {
+		t.Errorf("expected the large cookie to be rejected, got %s", body)
+	}
+}
+
+// TestCookieKeyRotation tests cookies of old keys are accepted while the keys
+// are kept.
+func TestCookieKeyRotation(t *testing.T) {
+	old := cookieApp(cobalt.CookieOptions{
+		Keys:           [][]byte{[]byte("sign-1")},
+		EncryptionKeys: [][]byte{[]byte("0123456789abcdef")},
+	})
+	rotated := cookieApp(cobalt.CookieOptions{
+		Keys:           [][]byte{[]byte("sign-2"), []byte("sign-1")},
+		EncryptionKeys: [][]byte{[]byte("fedcba9876543210"), []byte("0123456789abcdef")},
+	})
+	removed := cookieApp(cobalt.CookieOptions{
+		Keys:           [][]byte{[]byte("sign-2")},
+		EncryptionKeys: [][]byte{[]byte("fedcba9876543210")},
+	})
+
+	if body := roundTrip(t, old, rotated, nil); !strings.Contains(body, `"prefs_ok":true,"secret":"s3cret","secret_ok":true`) {
+		t.Errorf("expected cookies of the old keys to be accepted, got %s", body)
+	}
+	if body := roundTrip(t, rotated, removed, nil); !strings.Contains(body, `"prefs_ok":true,"secret":"s3cret","secret_ok":true`) {
+		t.Errorf("expected cookies of the new keys to be accepted, got %s", body)
+	}
+	if body := roundTrip(t, old, removed, nil); !strings.Contains(body, `"prefs_ok":false`) || !strings.Contains(body, `"secret_ok":false`) {
+		t.Errorf("expected cookies of the removed keys to be rejected, got %s", body)
+	}
+}
+
+// TestCookieOptions tests the options of a cookie override Cobalt.Cookies and
+// cookies can be deleted.
+func TestCookieOptions(t *testing.T) {
+	c := quiet()
+	c.Cookies = cobalt.CookieOptions{Keys: [][]byte{[]byte("sign-1")}, Domain: "example.com"}
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.SetSignedCookie("csrf", "token", cobalt.CookieOptions{Script: true, SameSite: http.SameSiteStrictMode, MaxAge: time.Hour})
+		ctx.DeleteCookie("old")
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+
+	cookies := get(c, "/").Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("expected 2 cookies, got %v", cookies)
+	}
+	if ck := cookies[0]; ck.HttpOnly || ck.SameSite != http.SameSiteStrictMode || ck.MaxAge != 3600 || ck.Domain != "" {
+		t.Errorf("expected the options of the cookie, got %s", ck)
+	}
+	if ck := cookies[1]; ck.Name != "old" || ck.MaxAge != -1 || ck.Domain != "example.com" {
+		t.Errorf("expected the cookie to be deleted, got %s", ck)
+	}
+}
@@ -0,0 +1,286 @@
+package cobalt
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS headers.
+const (
+	originHeader           = "Origin"
+	varyHeader             = "Vary"
+	allowOriginHeader      = "Access-Control-Allow-Origin"
+	allowCredentialsHeader = "Access-Control-Allow-Credentials"
+	allowMethodsHeader     = "Access-Control-Allow-Methods"
+	allowHeadersHeader     = "Access-Control-Allow-Headers"
+	exposeHeadersHeader    = "Access-Control-Expose-Headers"
+	maxAgeHeader           = "Access-Control-Max-Age"
+	requestMethodHeader    = "Access-Control-Request-Method"
+	requestHeadersHeader   = "Access-Control-Request-Headers"
+)
+
+// defaultCORSHeaders are the request headers allowed when
+// CORSOptions.AllowedHeaders is empty.
+var defaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Origin", "X-Requested-With"}
+
+// CORSOptions configures the CORS middleware. The zero value allows no
+// origins.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to make requests. An origin is
+	// either exact, like "https://example.com", a wildcard subdomain, like
+	// "https://*.example.com", or "*" for any origin.
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns are regular expressions matching the whole of
+	// allowed origins.
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowOrigin is called for origins not matched otherwise and reports
+	// whether they are allowed.
+	AllowOrigin func(ctx *Context, origin string) bool
+
+	// AllowedMethods limits the methods allowed in preflight requests. It
+	// defaults to the methods registered for the path.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed in preflight requests.
+	// "*" allows any header. It defaults to Accept, Accept-Language,
+	// Content-Language, Content-Type, Origin and X-Requested-With.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies or credentials. The
+	// origin is then always echoed instead of "*".
+	AllowCredentials bool
+
+	// MaxAge is how long the result of a preflight request may be cached.
+	// It is not sent when zero.
+	MaxAge time.Duration
+}
+
+// corsHandler is the state of a CORS middleware.
+type corsHandler struct {
+	o         CORSOptions
+	allowed   map[string]bool
+	anyHeader bool
+	methods   map[string]bool
+	exposed   string
+	maxAge    string
+}
+
+// CORS returns middleware implementing Cross-Origin Resource Sharing. Allowed
+// requests get the CORS response headers and preflight requests for a route
+// are answered with the methods registered for its path. Routes apply it
+// before running any middleware, so preflight requests aren't rejected by
+// authentication or counted by rate limits. CORS may be used globally and
+// overridden for groups and routes: the CORS middleware closest to the route
+// handles its requests. It must be passed to Use, Group or the methods adding
+// routes; CORS middleware wrapped by other middleware is ignored.
+//
+// Example
+//
+//	c.Use(cobalt.CORS(cobalt.CORSOptions{
+//		AllowedOrigins: []string{"https://*.example.com"},
+//		MaxAge:         time.Hour,
+//	}))
+func CORS(o CORSOptions) MiddleWare {
+	headers := o.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	ch := corsHandler{
+		o:       o,
+		allowed: make(map[string]bool, len(headers)),
+		methods: make(map[string]bool, len(o.AllowedMethods)),
+		exposed: strings.Join(o.ExposedHeaders, ", "),
+	}
+	for _, h := range headers {
+		if h == "*" {
+			ch.anyHeader = true
+		}
+		ch.allowed[strings.ToLower(h)] = true
+	}
+	for _, m := range o.AllowedMethods {
+		ch.methods[strings.ToUpper(m)] = true
+	}
+	if o.MaxAge > 0 {
+		ch.maxAge = strconv.Itoa(int(o.MaxAge / time.Second))
+	}
+
+	return ch.wrap
+}
+
+// wrap records ch as the CORS middleware of the request, for recordRoute. The
+// headers are set by the route before any middleware runs.
+func (ch *corsHandler) wrap(h Handler) Handler {
+	return func(ctx *Context) {
+		ctx.cors = ch
+		h(ctx)
+	}
+}
+
+// setHeaders sets the CORS headers of actual requests.
+func (ch *corsHandler) setHeaders(ctx *Context) {
+	header := ctx.Response.Header()
+	origin := ctx.Request.Header.Get(originHeader)
+
+	header.Add(varyHeader, originHeader)
+	if origin != "" && ch.o.allowed(ctx, origin) {
+		ch.o.setOrigin(header, origin)
+		if ch.exposed != "" {
+			header.Set(exposeHeadersHeader, ch.exposed)
+		}
+	}
+}
+
+// answer answers a preflight request for a path with methods registered.
+func (ch *corsHandler) answer(ctx *Context, methods []string) {
+	o := ch.o
+	header := ctx.Response.Header()
+	origin := ctx.Request.Header.Get(originHeader)
+
+	header.Add(varyHeader, originHeader)
+	header.Add(varyHeader, requestMethodHeader)
+	header.Add(varyHeader, requestHeadersHeader)
+
+	var allow []string
+	for _, m := range methods {
+		if len(ch.methods) == 0 || ch.methods[m] {
+			allow = append(allow, m)
+		}
+	}
+
+	method := ctx.Request.Header.Get(requestMethodHeader)
+	if !o.allowed(ctx, origin) || !contains(allow, method) {
+		ctx.ServeStatus(http.StatusNoContent)
+		return
+	}
+
+	requested := splitHeaderList(ctx.Request.Header.Get(requestHeadersHeader))
+	for _, r := range requested {
+		if !ch.anyHeader && !ch.allowed[strings.ToLower(r)] {
+			ctx.ServeStatus(http.StatusNoContent)
+			return
+		}
+	}
+
+	o.setOrigin(header, origin)
+	header.Set(allowMethodsHeader, strings.Join(allow, ", "))
+	if len(requested) > 0 {
+		header.Set(allowHeadersHeader, strings.Join(requested, ", "))
+	}
+	if ch.maxAge != "" {
+		header.Set(maxAgeHeader, ch.maxAge)
+	}
+	ctx.ServeStatus(http.StatusNoContent)
+}
+
+// allowed reports whether requests from origin are allowed.
+func (o CORSOptions) allowed(ctx *Context, origin string) bool {
+	for _, a := range o.AllowedOrigins {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+
+		// Wildcard subdomains, such as https://*.example.com.
+		if i := strings.Index(a, "*."); i >= 0 {
+			prefix, suffix := a[:i], a[i+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.EqualFold(origin[:len(prefix)], prefix) &&
+				strings.EqualFold(origin[len(origin)-len(suffix):], suffix) {
+				return true
+			}
+		}
+	}
+
+	for _, re := range o.AllowedOriginPatterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return o.AllowOrigin != nil && o.AllowOrigin(ctx, origin)
+}
+
+// setOrigin sets the allowed origin and credentials headers.
+func (o CORSOptions) setOrigin(header http.Header, origin string) {
+	if !o.AllowCredentials && contains(o.AllowedOrigins, "*") {
+		header.Set(allowOriginHeader, "*")
+		return
+	}
+
+	header.Set(allowOriginHeader, origin)
+	if o.AllowCredentials {
+		header.Set(allowCredentialsHeader, "true")
+	}
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderList splits a comma separated header value.
+func splitHeaderList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// preflightKey is the key for the preflightRequest of a request in a
+// context.Context.
+type preflightKey struct{}
+
+// preflightRequest is a CORS preflight request dispatched to a route.
+type preflightRequest struct {
+	methods  []string // registered for the path
+	answered bool     // by the CORS middleware of the route
+}
+
+// preflight dispatches a CORS preflight request to the route for the method
+// it asks for. It returns false if there is no such route or the route has no
+// CORS middleware.
+func (c *Cobalt) preflight(w http.ResponseWriter, req *http.Request) bool {
+	method := req.Header.Get(requestMethodHeader)
+	if req.Header.Get(originHeader) == "" || method == "" {
+		return false
+	}
+
+	path := req.URL.Path
+	handle, params, _ := c.router.Lookup(method, path)
+	if handle == nil {
+		return false
+	}
+
+	var methods []string
+	for m := range c.methods {
+		if m == "OPTIONS" {
+			continue
+		}
+		if h, _, _ := c.router.Lookup(m, path); h != nil {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+
+	pf := preflightRequest{methods: methods}
+	handle(w, req.WithContext(context.WithValue(req.Context(), preflightKey{}, &pf)), params)
+	return pf.answered
+}
@@ -0,0 +1,201 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// corsApp returns a cobalt with global CORS and an override for a group.
+func corsApp() *cobalt.Cobalt {
+	c := quiet()
+	c.Use(cobalt.CORS(cobalt.CORSOptions{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowOrigin: func(ctx *cobalt.Context, origin string) bool {
+			return origin == "https://partner.test"
+		},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+
+	handler := func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}
+	c.Get("/items/:id", handler)
+	c.Put("/items/:id", handler)
+	c.Delete("/items/:id", handler)
+
+	public := c.Group("/public", cobalt.CORS(cobalt.CORSOptions{AllowedOrigins: []string{"*"}}))
+	public.Get("/feed", handler)
+
+	internal := c.Group("/internal", cobalt.CORS(cobalt.CORSOptions{}))
+	internal.Get("/stats", handler)
+	return c
+}
+
+// TestCORSActualRequest tests the CORS headers of actual requests.
+func TestCORSActualRequest(t *testing.T) {
+	tests := []struct {
+		path, origin, allow, credentials string
+	}{
+		{"/items/1", "https://app.example.com", "https://app.example.com", "true"},
+		{"/items/1", "https://eu.shop.example.org", "https://eu.shop.example.org", "true"},
+		{"/items/1", "http://localhost:3000", "http://localhost:3000", "true"},
+		{"/items/1", "https://partner.test", "https://partner.test", "true"},
+		{"/items/1", "https://example.org", "", ""},
+		{"/items/1", "https://evil.test", "", ""},
+		{"/public/feed", "https://evil.test", "*", ""},
+	}
+
+	c := corsApp()
+	for _, tt := range tests {
+		r := NewRequest("GET", tt.path, nil)
+		r.Header.Set("Origin", tt.origin)
+		w := do(c, r)
+
+		h := w.Header()
+		if w.Code != http.StatusOK || h.Get("Access-Control-Allow-Origin") != tt.allow || h.Get("Access-Control-Allow-Credentials") != tt.credentials {
+			t.Errorf("%s %s: expected origin %q credentials %q, got %d %q %q", tt.path, tt.origin, tt.allow, tt.credentials,
+				w.Code, h.Get("Access-Control-Allow-Origin"), h.Get("Access-Control-Allow-Credentials"))
+		}
+		if len(h.Values("Vary")) != 1 || h.Get("Vary") != "Origin" {
+			t.Errorf("%s %s: expected Vary: Origin once, got %v", tt.path, tt.origin, h.Values("Vary"))
+		}
+		if tt.allow != "" && tt.allow != "*" && h.Get("Access-Control-Expose-Headers") != "X-Total" {
+			t.Errorf("%s %s: expected exposed headers", tt.path, tt.origin)
+		}
+	}
+}
+
+// TestCORSPreflight tests preflight requests are answered with the methods
+// registered for the path.
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		name, path, origin, method, headers string
+		methods, allowHeaders               string
+	}{
+		{"allowed", "/items/1", "https://app.example.com", "PUT", "content-type, authorization", "DELETE, GET, PUT", "content-type, authorization"},
+		{"header not allowed", "/items/1", "https://app.example.com", "PUT", "X-Secret", "", ""},
+		{"origin not allowed", "/items/1", "https://evil.test", "PUT", "", "", ""},
+		{"group override", "/public/feed", "https://evil.test", "GET", "", "GET", ""},
+		{"group disabled", "/internal/stats", "https://app.example.com", "GET", "", "", ""},
+	}
+
+	c := corsApp()
+	for _, tt := range tests {
+		r := NewRequest("OPTIONS", tt.path, nil)
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", tt.method)
+		if tt.headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", tt.headers)
+		}
+		w := do(c, r)
+
+		h := w.Header()
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204, got %d", tt.name, w.Code)
+		}
+		if h.Get("Access-Control-Allow-Methods") != tt.methods || h.Get("Access-Control-Allow-Headers") != tt.allowHeaders {
+			t.Errorf("%s: expected methods %q headers %q, got %q %q", tt.name, tt.methods, tt.allowHeaders,
+				h.Get("Access-Control-Allow-Methods"), h.Get("Access-Control-Allow-Headers"))
+		}
+		if allowed := h.Get("Access-Control-Allow-Origin") != ""; allowed != (tt.methods != "") {
+			t.Errorf("%s: expected origin to be allowed %t", tt.name, tt.methods != "")
+		}
+	}
+
+	// Max age is sent with successful preflights.
+	r := NewRequest("OPTIONS", "/items/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w := do(c, r)
+	if w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("expected max age 600, got %q", w.Header().Get("Access-Control-Max-Age"))
+	}
+
+	// Preflights for methods that aren't registered are left to the router.
+	r = NewRequest("OPTIONS", "/items/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w = do(c, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no CORS headers for an unregistered method")
+	}
+
+	// Preflight requests for routes without CORS middleware are left to the
+	// router.
+	plain := quiet()
+	plain.Get("/", func(ctx *cobalt.Context) {
+		t.Error("expected the handler not to run for a preflight request")
+	})
+	r = NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w = do(plain, r)
+	if w.Header().Get("Allow") != "GET, OPTIONS" || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected Allow header without CORS headers, got %v", w.Header())
+	}
+}
+
+// TestCORSPreflightFirst tests preflight requests are answered before any
+// middleware runs, and routes without CORS middleware serve OPTIONS requests
+// themselves.
+func TestCORSPreflightFirst(t *testing.T) {
+	c := quiet(func(h cobalt.Handler) cobalt.Handler {
+		return func(ctx *cobalt.Context) {
+			ctx.ServeStatus(http.StatusUnauthorized)
+		}
+	})
+	c.Delete("/users/:id", func(ctx *cobalt.Context) {
+		t.Error("expected the handler not to run for a preflight request")
+	}, cobalt.CORS(cobalt.CORSOptions{AllowedOrigins: []string{"*"}}))
+
+	r := NewRequest("OPTIONS", "/users/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w := do(c, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "DELETE" {
+		t.Errorf("expected the preflight request to be allowed, got %d %v", w.Code, w.Header())
+	}
+
+	var called bool
+	plain := quiet()
+	plain.Get("/", func(ctx *cobalt.Context) {})
+	plain.Options("/", func(ctx *cobalt.Context) {
+		called = true
+		ctx.ServeStatus(http.StatusOK)
+	})
+	r = NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w = do(plain, r)
+	if !called || w.Code != http.StatusOK {
+		t.Errorf("expected the OPTIONS route to serve the request, got %d", w.Code)
+	}
+}
+
+// TestCORSClosestToRoute tests the CORS middleware of a route handles its
+// requests, whatever the order middleware was added in.
+func TestCORSClosestToRoute(t *testing.T) {
+	c := quiet()
+	c.Get("/widget", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}, cobalt.CORS(cobalt.CORSOptions{AllowedOrigins: []string{"*"}}))
+	c.Use(cobalt.CORS(cobalt.CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}))
+
+	w := get(c, "/widget", "Origin", "https://evil.test")
+	if h := w.Header(); h.Get("Access-Control-Allow-Origin") != "*" || len(h.Values("Vary")) != 1 {
+		t.Errorf("expected only the headers of the route, got %v", h)
+	}
+
+	r := request("OPTIONS", "/widget", nil, "Origin", "https://evil.test", "Access-Control-Request-Method", "GET")
+	if w := do(c, r); w.Header().Get("Access-Control-Allow-Methods") != "GET" {
+		t.Errorf("expected the preflight to be answered by the route, got %v", w.Header())
+	}
+}
@@ -0,0 +1,314 @@
+package cobalt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Defaults for CSRF.
+const (
+	defaultCSRFCookie = "_csrf"
+	defaultCSRFField  = "csrf_token"
+	defaultCSRFHeader = "X-CSRF-Token"
+)
+
+// csrfSessionKey is the key of the CSRF secret in sessions.
+const csrfSessionKey = "_csrf"
+
+// csrfSecretLen is the length of CSRF secrets in bytes.
+const csrfSecretLen = 32
+
+var (
+	// ErrCSRFToken is returned for requests without a valid CSRF token.
+	ErrCSRFToken = errors.New("cobalt: invalid csrf token")
+
+	// ErrCSRFOrigin is returned for requests sent from other origins.
+	ErrCSRFOrigin = errors.New("cobalt: cross-origin request")
+
+	// ErrCSRFKeys is returned when the secret of the tokens would be kept
+	// in a cookie but Cobalt.Cookies has no Keys to sign it with. No tokens
+	// are issued then, and unsafe requests are rejected.
+	ErrCSRFKeys = errors.New("cobalt: csrf cookies require Cookies.Keys")
+)
+
+type (
+	// CSRFOptions configures the CSRF middleware.
+	CSRFOptions struct {
+		// Session keeps the secret of the tokens in the session of the
+		// request, which requires the route to use the Sessions middleware.
+		// The secret is kept in a cookie signed with the Keys of
+		// Cobalt.Cookies otherwise, which the token must match.
+		Session bool
+
+		// CookieName is the name of the cookie keeping the secret when
+		// Session isn't set. It defaults to "_csrf".
+		CookieName string
+
+		// Insecure lets the cookie be sent over plain HTTP, for development.
+		Insecure bool
+
+		// Field is the name of the form field with the token. It defaults to
+		// "csrf_token".
+		Field string
+
+		// Header is the request header with the token, for requests sent by
+		// scripts. It defaults to "X-CSRF-Token".
+		Header string
+
+		// TrustedOrigins are other origins allowed to send requests, such as
+		// "https://admin.example.com".
+		TrustedOrigins []string
+
+		// Body is encoded with the Coder and served with a 403 status to
+		// rejected requests. It defaults to a JSON error object.
+		Body interface{}
+
+		// OnError is called after a rejected request is answered, for example
+		// to log it.
+		OnError func(ctx *Context, err error)
+	}
+
+	// csrfManager verifies the requests of a CSRF middleware.
+	csrfManager struct {
+		o CSRFOptions
+	}
+
+	// csrfBody is the default body served to rejected requests.
+	csrfBody struct {
+		Error string `json:"error"`
+	}
+)
+
+// CSRF returns middleware protecting requests with unsafe methods, such as
+// form posts, from cross-site request forgery. Such requests are rejected if
+// the browser reports they were sent from another site or origin, and must
+// carry the token of Context.CSRFToken in a form field or header. Templates
+// served with ServeHTML add the token to forms with csrfField, or get it with
+// csrfToken.
+//
+// Requests are checked after all the middleware of the route ran, right
+// before the handler. The Origin and Referer headers are compared with the
+// host of the request only, so pages served over HTTPS by a proxy are
+// accepted even if the proxy isn't trusted.
+//
+// Routes that don't serve browsers, such as API routes authenticated with
+// tokens, are exempted with CSRFExempt.
+//
+// Example
+//
+//	c.Use(cobalt.CSRF())
+//	c.Post("/api/hooks", hook, cobalt.CSRFExempt())
+//
+//	<form method="post">{{ csrfField }}...</form>
+func CSRF(options ...CSRFOptions) MiddleWare {
+	var o CSRFOptions
+	if len(options) > 0 {
+		o = options[0]
+	}
+	if o.CookieName == "" {
+		o.CookieName = defaultCSRFCookie
+	}
+	if o.Field == "" {
+		o.Field = defaultCSRFField
+	}
+	if o.Header == "" {
+		o.Header = defaultCSRFHeader
+	}
+	if o.Body == nil {
+		o.Body = csrfBody{Error: "forbidden"}
+	}
+
+	m := csrfManager{o: o}
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			ctx.csrf = &m
+			h(ctx)
+		}
+	}
+}
+
+// CSRFExempt returns middleware exempting a route from the checks of CSRF,
+// wherever CSRF was added.
+func CSRFExempt() MiddleWare {
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			ctx.csrfExempt = true
+			h(ctx)
+		}
+	}
+}
+
+// CSRFToken returns the CSRF token of the request, to be sent back in the
+// form field or header of the CSRF middleware. Tokens are masked differently
+// every time, so they can't be recovered from compressed pages. It returns ""
+// if the route doesn't use the CSRF middleware.
+func (c *Context) CSRFToken() string {
+	if c.csrf == nil {
+		return ""
+	}
+
+	secret := c.csrf.secret(c, true)
+	if secret == nil {
+		return ""
+	}
+	token := make([]byte, 2*csrfSecretLen)
+	if _, err := rand.Read(token[:csrfSecretLen]); err != nil {
+		c.Logger().Error("csrf token failed", Field{"error", err})
+		return ""
+	}
+	for i := range secret {
+		token[csrfSecretLen+i] = token[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// CSRFField returns a hidden form field with the CSRF token of the request,
+// or "" if the route doesn't use the CSRF middleware.
+func (c *Context) CSRFField() template.HTML {
+	if c.csrf == nil {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(c.csrf.o.Field) +
+		`" value="` + c.CSRFToken() + `">`)
+}
+
+// checkCSRF checks the request with the CSRF middleware of the route, if
+// any, answering it if it is rejected.
+func (c *Context) checkCSRF() bool {
+	if c.csrf == nil || c.csrfExempt || safeMethod(c.Request.Method) {
+		return true
+	}
+
+	err := c.csrf.verify(c)
+	if err == nil {
+		return true
+	}
+	c.ServeWithStatus(c.csrf.o.Body, http.StatusForbidden)
+	if c.csrf.o.OnError != nil {
+		c.csrf.o.OnError(c, err)
+	}
+	return false
+}
+
+// verify checks the origin and token of a request.
+func (m *csrfManager) verify(ctx *Context) error {
+	req := ctx.Request
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		if u, err := url.Parse(req.Referer()); err == nil && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		if !m.trusted(origin) {
+			return ErrCSRFOrigin
+		}
+	}
+	if origin != "" && !m.trusted(origin) {
+		// The scheme isn't compared, as proxies terminating TLS forward
+		// requests over plain HTTP.
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, ctx.Host()) {
+			return ErrCSRFOrigin
+		}
+	}
+
+	token := req.Header.Get(m.o.Header)
+	if token == "" {
+		token = req.PostFormValue(m.o.Field)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*csrfSecretLen {
+		return ErrCSRFToken
+	}
+	for i := 0; i < csrfSecretLen; i++ {
+		b[csrfSecretLen+i] ^= b[i]
+	}
+
+	if !m.o.Session && len(ctx.cookies.Keys) == 0 {
+		return ErrCSRFKeys
+	}
+	secret := m.secret(ctx, false)
+	if secret == nil || subtle.ConstantTimeCompare(b[csrfSecretLen:], secret) != 1 {
+		return ErrCSRFToken
+	}
+	return nil
+}
+
+// trusted reports whether requests from origin are allowed.
+func (m *csrfManager) trusted(origin string) bool {
+	for _, o := range m.o.TrustedOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// secret returns the CSRF secret of the request, creating it if create is set
+// and there is none.
+func (m *csrfManager) secret(ctx *Context, create bool) []byte {
+	if ctx.csrfSecret != nil {
+		return ctx.csrfSecret
+	}
+
+	// Unsigned cookies could be set by another site of the domain, along
+	// with tokens matching them.
+	if !m.o.Session && len(ctx.cookies.Keys) == 0 {
+		if create {
+			ctx.Logger().Error("csrf secret failed", Field{"error", ErrCSRFKeys})
+		}
+		return nil
+	}
+
+	var secret []byte
+	if m.o.Session {
+		ctx.Session().Get(csrfSessionKey, &secret)
+	} else {
+		ctx.SignedCookie(m.o.CookieName, &secret)
+	}
+	if len(secret) == csrfSecretLen {
+		ctx.csrfSecret = secret
+		return secret
+	}
+	if !create {
+		return nil
+	}
+
+	secret = make([]byte, csrfSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		ctx.Logger().Error("csrf secret failed", Field{"error", err})
+		return nil
+	}
+	if m.o.Session {
+		if err := ctx.Session().Set(csrfSessionKey, secret); err != nil {
+			return nil
+		}
+	} else {
+		err := ctx.SetSignedCookie(m.o.CookieName, secret, CookieOptions{Insecure: m.o.Insecure})
+		if err != nil {
+			return nil
+		}
+	}
+	ctx.csrfSecret = secret
+	return secret
+}
+
+// safeMethod reports whether method doesn't change state, so it doesn't need
+// CSRF protection.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
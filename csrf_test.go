@@ -0,0 +1,174 @@
+package cobalt_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// csrfValue matches the tokens of the form field and meta tag of form.tmpl.
+var csrfValue = regexp.MustCompile(`(?:value|content)="([^"]+)"`)
+
+// csrfApp returns a cobalt serving a form at /form, accepting it at /form
+// and exempting /hook.
+func csrfApp(m ...cobalt.MiddleWare) *cobalt.Cobalt {
+	c := quiet(m...)
+	c.Cookies.Keys = [][]byte{[]byte("key-1")}
+	c.Templates.Directory = "_testdata/templates"
+
+	c.Get("/form", func(ctx *cobalt.Context) {
+		ctx.ServeHTML("form", nil, cobalt.HTMLOptions{NoLayout: true})
+	})
+	c.Post("/form", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Post("/hook", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusNoContent)
+	}, cobalt.CSRFExempt())
+	return c
+}
+
+// csrfForm serves the form to cl, returning the tokens of the field and meta
+// tag.
+func csrfForm(t *testing.T, cl *client) []string {
+	t.Helper()
+	w := cl.get("/form")
+
+	var tokens []string
+	for _, m := range csrfValue.FindAllStringSubmatch(w.Body.String(), -1) {
+		tokens = append(tokens, m[1])
+	}
+	if len(tokens) != 2 || !strings.Contains(w.Body.String(), `name="csrf_token"`) {
+		t.Fatalf("expected the form to have tokens, got %s", w.Body.String())
+	}
+	return tokens
+}
+
+// csrfPost posts the form with the token to path of example.com, returning
+// the status.
+func csrfPost(cl *client, path, token string, header ...string) int {
+	return cl.post(path, url.Values{"csrf_token": {token}}, append([]string{"Host", "example.com"}, header...)...).Code
+}
+
+// TestCSRF tests unsafe requests need the token of the cookie and must come
+// from the same origin.
+func TestCSRF(t *testing.T) {
+	var failed error
+	c := csrfApp(cobalt.CSRF(cobalt.CSRFOptions{
+		TrustedOrigins: []string{"https://admin.example.com"},
+		OnError:        func(ctx *cobalt.Context, err error) { failed = err },
+	}))
+
+	cl := newClient(c)
+	tokens := csrfForm(t, cl)
+	if ck := cl.cookies["_csrf"]; len(cl.cookies) != 1 || ck == nil || !ck.HttpOnly || !ck.Secure {
+		t.Fatalf("expected a secure cookie, got %v", cl.cookies)
+	}
+	if tokens[0] == tokens[1] {
+		t.Errorf("expected tokens to be masked differently, got %q", tokens)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		cl     *client
+		header []string
+		code   int
+		err    error
+	}{
+		{"field", "/form", tokens[0], cl, nil, http.StatusNoContent, nil},
+		{"meta", "/form", tokens[1], cl, nil, http.StatusNoContent, nil},
+		{"header", "/form", "", cl, []string{"X-Csrf-Token", tokens[0]}, http.StatusNoContent, nil},
+		{"no token", "/form", "", cl, nil, http.StatusForbidden, cobalt.ErrCSRFToken},
+		{"no cookie", "/form", tokens[0], newClient(c), nil, http.StatusForbidden, cobalt.ErrCSRFToken},
+		{"same origin", "/form", tokens[0], cl, []string{"Origin", "http://example.com", "Sec-Fetch-Site", "same-origin"}, http.StatusNoContent, nil},
+		{"https proxy", "/form", tokens[0], cl, []string{"Origin", "https://example.com", "Sec-Fetch-Site", "same-origin"}, http.StatusNoContent, nil},
+		{"other host", "/form", tokens[0], cl, []string{"Origin", "https://example.com.evil.example"}, http.StatusForbidden, cobalt.ErrCSRFOrigin},
+		{"cross origin", "/form", tokens[0], cl, []string{"Origin", "https://evil.example"}, http.StatusForbidden, cobalt.ErrCSRFOrigin},
+		{"cross referer", "/form", tokens[0], cl, []string{"Referer", "https://evil.example/page"}, http.StatusForbidden, cobalt.ErrCSRFOrigin},
+		{"cross site", "/form", tokens[0], cl, []string{"Sec-Fetch-Site", "cross-site"}, http.StatusForbidden, cobalt.ErrCSRFOrigin},
+		{"trusted", "/form", tokens[0], cl, []string{"Origin", "https://admin.example.com", "Sec-Fetch-Site", "same-site"}, http.StatusNoContent, nil},
+		{"exempt", "/hook", "", newClient(c), []string{"Sec-Fetch-Site", "cross-site"}, http.StatusNoContent, nil},
+	}
+	for _, tt := range tests {
+		failed = nil
+		if code := csrfPost(tt.cl, tt.path, tt.token, tt.header...); code != tt.code || failed != tt.err {
+			t.Errorf("%s: expected %d %v, got %d %v", tt.name, tt.code, tt.err, code, failed)
+		}
+	}
+
+	other := newClient(c)
+	otherTokens := csrfForm(t, other)
+	if code := csrfPost(cl, "/form", otherTokens[0]); code != http.StatusForbidden {
+		t.Errorf("expected the token of another client to be rejected, got %d", code)
+	}
+	if again := csrfForm(t, other); len(again) != 2 || csrfPost(other, "/form", again[0]) != http.StatusNoContent {
+		t.Errorf("expected the cookie to be kept between pages")
+	}
+}
+
+// TestCSRFCookieKeys tests the cookie of the secret is signed, and that
+// tokens aren't issued without keys to sign it with.
+func TestCSRFCookieKeys(t *testing.T) {
+	var failed error
+	c := csrfApp(cobalt.CSRF(cobalt.CSRFOptions{
+		OnError: func(ctx *cobalt.Context, err error) { failed = err },
+	}))
+
+	// The token of a secret set by another site of the domain.
+	secret := strings.Repeat("\x01", 32)
+	token := base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat("\x00", 32) + secret))
+	forged := newClient(c)
+	forged.cookies["_csrf"] = &http.Cookie{Name: "_csrf", Value: base64.RawURLEncoding.EncodeToString([]byte(secret))}
+	if code := csrfPost(forged, "/form", token); code != http.StatusForbidden || failed != cobalt.ErrCSRFToken {
+		t.Errorf("expected an unsigned cookie to be rejected, got %d %v", code, failed)
+	}
+
+	c.Cookies.Keys = nil
+	cl := newClient(c)
+	if w := cl.get("/form"); !strings.Contains(w.Body.String(), `value=""`) || cl.cookies["_csrf"] != nil {
+		t.Errorf("expected no secret without keys, got %v", cl.cookies)
+	}
+	if code := csrfPost(cl, "/form", token); code != http.StatusForbidden || failed != cobalt.ErrCSRFKeys {
+		t.Errorf("expected requests to be rejected without keys, got %d %v", code, failed)
+	}
+}
+
+// TestCSRFSession tests tokens can be tied to sessions, whichever of the
+// middleware runs first.
+func TestCSRFSession(t *testing.T) {
+	csrf := cobalt.CSRF(cobalt.CSRFOptions{Session: true})
+	sessions := cobalt.Sessions(cobalt.SessionOptions{Store: cobalt.NewMemorySessionStore(), Keys: [][]byte{[]byte("key-1")}})
+
+	for i, c := range []*cobalt.Cobalt{csrfApp(csrf, sessions), csrfApp(sessions, csrf)} {
+		cl := newClient(c)
+		tokens := csrfForm(t, cl)
+		if len(cl.cookies) != 1 || cl.cookies["session"] == nil {
+			t.Fatalf("%d: expected only the session cookie, got %v", i, cl.cookies)
+		}
+		if code := csrfPost(cl, "/form", tokens[0]); code != http.StatusNoContent {
+			t.Errorf("%d: expected the token of the session to be accepted, got %d", i, code)
+		}
+
+		other := newClient(c)
+		csrfForm(t, other)
+		if code := csrfPost(other, "/form", tokens[0]); code != http.StatusForbidden {
+			t.Errorf("%d: expected the token of another session to be rejected, got %d", i, code)
+		}
+	}
+}
+
+// TestCSRFTemplates tests the template functions are empty without the CSRF
+// middleware.
+func TestCSRFTemplates(t *testing.T) {
+	w := get(csrfApp(), "/form")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post"></form>`) {
+		t.Errorf("expected an empty field, got %d %s", w.Code, w.Body.String())
+	}
+}
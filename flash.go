@@ -0,0 +1,165 @@
+package cobalt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// flashKey is the name of the cookie, or the key in sessions, keeping flash
+// messages.
+const flashKey = "_flash"
+
+// ErrFlashKeys is returned by Flash when messages would be kept in a cookie
+// but Cobalt.Cookies has no Keys to sign it with.
+var ErrFlashKeys = errors.New("cobalt: flash cookies require Cookies.Keys")
+
+type (
+	// Flash is a message shown once on the next page, such as the result of
+	// a form posted before a redirect.
+	Flash struct {
+		// Category groups messages, such as "error" or "success".
+		Category string `json:"category"`
+		Message  string `json:"message"`
+	}
+
+	// flashes are the flash messages of a request.
+	flashes struct {
+		loaded bool
+
+		// in are the messages of earlier requests not read yet, and out the
+		// messages added for the next requests.
+		in  []Flash
+		out []Flash
+	}
+)
+
+// clone returns a copy of f, whose messages are copied when they change.
+func (f flashes) clone() flashes {
+	f.in = f.in[:len(f.in):len(f.in)]
+	f.out = f.out[:len(f.out):len(f.out)]
+	return f
+}
+
+// Flash adds a message to be shown on the next request, usually before
+// Redirect. Messages are kept in the session when the route uses the
+// Sessions middleware, and in a cookie signed with the Keys of Cobalt.Cookies
+// otherwise. Unsigned cookies could be forged, so without Keys the message is
+// dropped and ErrFlashKeys returned.
+//
+// Example
+//
+//	ctx.Flash("success", "Your profile was saved.")
+//	ctx.Redirect("/profile", http.StatusSeeOther)
+func (c *Context) Flash(category, message string) error {
+	c.loadFlashes()
+	c.flashes.out = append(c.flashes.out, Flash{Category: category, Message: message})
+	return c.saveFlashes()
+}
+
+// Flashes returns the messages added by earlier requests in any of
+// categories, or all of them if there are no categories. Messages are only
+// returned once. Templates served with ServeHTML read them with flashes.
+//
+// Example
+//
+//	{{ range flashes "error" }}<p class="error">{{ .Message }}</p>{{ end }}
+func (c *Context) Flashes(categories ...string) []Flash {
+	c.loadFlashes()
+
+	var read, kept []Flash
+	for _, f := range c.flashes.in {
+		if len(categories) == 0 || containsAny(categories, []string{f.Category}) {
+			read = append(read, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if len(read) == 0 {
+		return nil
+	}
+
+	c.flashes.in = kept
+	if err := c.saveFlashes(); err != nil {
+		c.Logger().Error("flash save failed", Field{"error", err})
+	}
+	return read
+}
+
+// loadFlashes loads the messages of earlier requests, the first time.
+func (c *Context) loadFlashes() {
+	if c.flashes.loaded {
+		return
+	}
+	c.flashes.loaded = true
+
+	if c.sessions != nil {
+		c.Session().Get(flashKey, &c.flashes.in)
+		return
+	}
+
+	if len(c.cookies.Keys) == 0 {
+		return
+	}
+	v, err := c.Cookie(flashKey)
+	if err != nil {
+		return
+	}
+	b, err := decodeCookie(c.cookies.Keys, nil, flashKey, v)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(b, &c.flashes.in)
+}
+
+// saveFlashes stores the messages not read yet.
+func (c *Context) saveFlashes() error {
+	all := append(append([]Flash(nil), c.flashes.in...), c.flashes.out...)
+
+	if c.sessions != nil {
+		if len(all) == 0 {
+			c.Session().Delete(flashKey)
+			return nil
+		}
+		return c.Session().Set(flashKey, all)
+	}
+
+	// Only the last cookie set in a response is kept by browsers, so the
+	// cookie set earlier in the request is replaced.
+	removeSetCookie(c.Response.Header(), flashKey)
+	if len(all) == 0 {
+		if _, err := c.Request.Cookie(flashKey); err == nil {
+			c.DeleteCookie(flashKey)
+		}
+		return nil
+	}
+	if len(c.cookies.Keys) == 0 {
+		return ErrFlashKeys
+	}
+
+	b, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	v, err := encodeCookie(c.cookies.Keys, nil, flashKey, b)
+	if err != nil {
+		return err
+	}
+	return c.SetCookie(flashKey, v)
+}
+
+// removeSetCookie removes the cookie name from the cookies set by header.
+func removeSetCookie(header http.Header, name string) {
+	var kept []string
+	for _, v := range header.Values("Set-Cookie") {
+		if !strings.HasPrefix(v, name+"=") {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Set-Cookie")
+		return
+	}
+	header["Set-Cookie"] = kept
+}
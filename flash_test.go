@@ -0,0 +1,124 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// flashApp returns a cobalt adding flash messages at /save and showing them
+// at /page.
+func flashApp(m ...cobalt.MiddleWare) *cobalt.Cobalt {
+	c := quiet(m...)
+	c.Templates.Directory = "_testdata/templates"
+
+	c.Get("/save", func(ctx *cobalt.Context) {
+		ctx.Flash("success", "Saved.")
+		ctx.Flash("error", "Name is required.")
+		ctx.Redirect("/page", http.StatusSeeOther)
+	})
+	c.Get("/page", func(ctx *cobalt.Context) {
+		ctx.ServeHTML("flashes", nil, cobalt.HTMLOptions{NoLayout: true})
+	})
+	c.Get("/success", func(ctx *cobalt.Context) {
+		ctx.Serve(ctx.Flashes("success"))
+	})
+	return c
+}
+
+// TestFlashCookie tests messages are kept in a cookie until they are read.
+func TestFlashCookie(t *testing.T) {
+	c := flashApp()
+	c.Cookies.Keys = [][]byte{[]byte("sign-1")}
+	cl := newClient(c)
+
+	w := cl.get("/save")
+	if n := len(w.Header().Values("Set-Cookie")); w.Code != http.StatusSeeOther || n != 1 {
+		t.Fatalf("expected a redirect setting one cookie, got %d %v", w.Code, w.Header())
+	}
+	saved := cl.cookies["_flash"]
+
+	w = cl.get("/page")
+	expected := "<p class=\"error\">Name is required.</p>\n<p>success: Saved.</p>"
+	if text(w) != expected {
+		t.Errorf("expected the messages by category, got %q", text(w))
+	}
+	if set := cookieNamed(w, "_flash"); set == nil || set.MaxAge != -1 {
+		t.Errorf("expected the cookie to be deleted once read, got %v", set)
+	}
+	if b := text(cl.get("/page")); b != "" {
+		t.Errorf("expected no messages, got %q", b)
+	}
+
+	cl.cookies["_flash"] = saved
+	w = cl.get("/success")
+	if set := cookieNamed(w, "_flash"); text(w) != `[{"category":"success","message":"Saved."}]` || set == nil || set.MaxAge == -1 {
+		t.Fatalf("expected the messages of the category and the others to be kept, got %s %v", text(w), set)
+	}
+	if b := text(cl.get("/page")); b != `<p class="error">Name is required.</p>` {
+		t.Errorf("expected the messages not read yet, got %q", b)
+	}
+}
+
+// TestFlashSigned tests flash cookies are signed with the keys of cookies.
+func TestFlashSigned(t *testing.T) {
+	c := flashApp()
+	c.Cookies.Keys = [][]byte{[]byte("sign-1")}
+	cl := newClient(c)
+
+	cl.get("/save")
+	if b := text(cl.get("/page")); !strings.Contains(b, "Saved.") {
+		t.Errorf("expected the signed messages, got %q", b)
+	}
+
+	cl.cookies["_flash"] = &http.Cookie{Name: "_flash", Value: "W3siY2F0ZWdvcnkiOiJlcnJvciIsIm1lc3NhZ2UiOiJoYWNrZWQifV0"}
+	if b := text(cl.get("/page")); b != "" {
+		t.Errorf("expected unsigned messages to be ignored, got %q", b)
+	}
+}
+
+// TestFlashNoKeys tests messages aren't kept in unsigned cookies.
+func TestFlashNoKeys(t *testing.T) {
+	c := quiet()
+	c.Get("/save", func(ctx *cobalt.Context) {
+		if err := ctx.Flash("success", "Saved."); err != cobalt.ErrFlashKeys {
+			t.Errorf("expected ErrFlashKeys, got %v", err)
+		}
+		ctx.ServeStatus(http.StatusOK)
+	})
+	c.Get("/success", func(ctx *cobalt.Context) {
+		ctx.Serve(ctx.Flashes("success"))
+	})
+	cl := newClient(c)
+
+	if cl.get("/save"); cl.cookies["_flash"] != nil {
+		t.Errorf("expected no flash cookie, got %v", cl.cookies["_flash"])
+	}
+
+	cl.cookies["_flash"] = &http.Cookie{Name: "_flash", Value: "W3siY2F0ZWdvcnkiOiJzdWNjZXNzIiwibWVzc2FnZSI6ImhhY2tlZCJ9XQ"}
+	if b := text(cl.get("/success")); b != "null" {
+		t.Errorf("expected unsigned messages to be ignored, got %q", b)
+	}
+}
+
+// TestFlashSession tests messages are kept in the session of routes using
+// sessions.
+func TestFlashSession(t *testing.T) {
+	cl := newClient(flashApp(cobalt.Sessions(cobalt.SessionOptions{
+		Store: cobalt.NewMemorySessionStore(),
+		Keys:  [][]byte{[]byte("key-1")},
+	})))
+
+	cl.get("/save")
+	if cl.cookies["session"] == nil || cl.cookies["_flash"] != nil {
+		t.Fatalf("expected only a session, got %v", cl.cookies)
+	}
+	if b := text(cl.get("/page")); !strings.Contains(b, "Name is required.") || !strings.Contains(b, "success: Saved.") {
+		t.Errorf("expected the messages of the session, got %q", b)
+	}
+	if b := text(cl.get("/page")); b != "" {
+		t.Errorf("expected messages to be read once, got %q", b)
+	}
+}
@@ -0,0 +1,74 @@
+package cobalt
+
+// Group is a set of routes sharing a path prefix and middleware. The
+// middleware of a group runs after the middleware added with Use and before
+// the middleware of each route, so settings made by the middleware of a route
+// override the ones made by its group.
+type Group struct {
+	c      *Cobalt
+	parent *Group
+	prefix string
+	m      []MiddleWare
+}
+
+// Group creates a group of routes under prefix using the middleware m.
+//
+// Example
+//
+//	api := c.Group("/api", cobalt.Timeout(5*time.Second))
+//	api.Get("/users/:id", getUser)
+func (c *Cobalt) Group(prefix string, m ...MiddleWare) *Group {
+	return &Group{c: c, prefix: prefix, m: m}
+}
+
+// Group creates a group nested in g. Its routes run the middleware of g and
+// then m.
+func (g *Group) Group(prefix string, m ...MiddleWare) *Group {
+	return &Group{c: g.c, parent: g, prefix: g.prefix + prefix, m: m}
+}
+
+// Use adds middleware to the routes added to the group afterwards.
+func (g *Group) Use(m ...MiddleWare) {
+	g.m = append(g.m, m...)
+}
+
+// middleware returns m followed by the middleware of g and its parents.
+// Middleware is applied in order, so the last one runs first and the
+// middleware of the outermost group runs before the others.
+func (g *Group) middleware(m []MiddleWare) []MiddleWare {
+	all := append([]MiddleWare(nil), m...)
+	for ; g != nil; g = g.parent {
+		all = append(all, g.m...)
+	}
+	return all
+}
+
+// Get adds a route to the group that matches a GET verb in a request.
+func (g *Group) Get(route string, h Handler, m ...MiddleWare) {
+	g.c.route("GET", g.prefix+route, h, g.middleware(m))
+}
+
+// Post adds a route to the group that matches a POST verb in a request.
+func (g *Group) Post(route string, h Handler, m ...MiddleWare) {
+	g.c.route("POST", g.prefix+route, h, g.middleware(m))
+}
+
+// Put adds a route to the group that matches a PUT verb in a request.
+func (g *Group) Put(route string, h Handler, m ...MiddleWare) {
+	g.c.route("PUT", g.prefix+route, h, g.middleware(m))
+}
+
+// Delete adds a route to the group that matches a DELETE verb in a request.
+func (g *Group) Delete(route string, h Handler, m ...MiddleWare) {
+	g.c.route("DELETE", g.prefix+route, h, g.middleware(m))
+}
+
+// Options adds a route to the group that matches a OPTIONS verb in a request.
+func (g *Group) Options(route string, h Handler, m ...MiddleWare) {
+	g.c.route("OPTIONS", g.prefix+route, h, g.middleware(m))
+}
+
+// Head adds a route to the group that matches a HEAD verb in a request.
+func (g *Group) Head(route string, h Handler, m ...MiddleWare) {
+	g.c.route("HEAD", g.prefix+route, h, g.middleware(m))
+}
@@ -0,0 +1,240 @@
+package cobalt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hmacScheme is the scheme of signed requests in the Authorization header.
+const hmacScheme = "HMAC-SHA256"
+
+// nonceHeader is the request header with a value making a signed request
+// unique.
+const nonceHeader = "X-Nonce"
+
+// Defaults for HMAC.
+const (
+	defaultReplayWindow  = 5 * time.Minute
+	defaultMaxSignedBody = 10 << 20
+)
+
+// ErrReplay is returned for signed requests dated outside the replay window
+// or already seen.
+var ErrReplay = errors.New("cobalt: request replayed")
+
+type (
+	// HMACKeyStore looks up the secrets of signing keys.
+	HMACKeyStore interface {
+		// Secret returns the secret of the key with the ID keyID and the
+		// identity owning it, or a nil identity if there is no such key.
+		Secret(ctx context.Context, keyID string) ([]byte, *Identity, error)
+	}
+
+	// HMACKey is a signing key of HMACKeys.
+	HMACKey struct {
+		Secret   []byte
+		Identity Identity
+	}
+
+	// HMACKeys is an HMACKeyStore of keys by their ID.
+	HMACKeys map[string]HMACKey
+
+	// HMACOptions configures HMAC request signing authentication.
+	HMACOptions struct {
+		// Keys looks up the secrets of signing keys. It is required.
+		Keys HMACKeyStore
+
+		// Window is how far the Date of requests may be from the time of the
+		// server. Signatures are remembered for the window, so each signed
+		// request is only accepted once. It defaults to 5 minutes.
+		Window time.Duration
+
+		// MaxBody is the largest body of signed requests, in bytes. It
+		// defaults to 10MB.
+		MaxBody int64
+	}
+
+	// hmacAuth authenticates signed requests.
+	hmacAuth struct {
+		o HMACOptions
+
+		mu    sync.Mutex
+		seen  map[string]time.Time
+		swept time.Time
+	}
+)
+
+// Secret implements HMACKeyStore.
+func (k HMACKeys) Secret(ctx context.Context, keyID string) ([]byte, *Identity, error) {
+	key, ok := k[keyID]
+	if !ok {
+		return nil, nil, nil
+	}
+	id := key.Identity
+	return key.Secret, &id, nil
+}
+
+// SignRequest signs req with the key keyID and its secret. The signature
+// covers the method, path and query, Date header, X-Nonce header and body of
+// the request. The Date header is set to now if it is missing. Clients
+// repeating identical requests within a second must set a different X-Nonce
+// header on each, since every signature is only accepted once.
+func SignRequest(req *http.Request, keyID string, secret []byte) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	sig := signature(secret, req, body)
+	req.Header.Set("Authorization", fmt.Sprintf(`%s keyId=%q, signature=%q`, hmacScheme, keyID, sig))
+	return nil
+}
+
+// signature returns the base64 encoded signature of a request.
+func signature(secret []byte, req *http.Request, body []byte) string {
+	digest := sha256.Sum256(body)
+	uri := req.URL.RequestURI()
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", req.Method, uri, req.Header.Get("Date"), req.Header.Get(nonceHeader), hex.EncodeToString(digest[:]))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HMAC returns an Authenticator of requests signed with SignRequest. The
+// body of signed requests is read to verify the signature and is available to
+// handlers as usual.
+func HMAC(o HMACOptions) Authenticator {
+	if o.Keys == nil {
+		panic("cobalt: HMAC requires an HMACKeyStore")
+	}
+	if o.Window == 0 {
+		o.Window = defaultReplayWindow
+	}
+	if o.MaxBody == 0 {
+		o.MaxBody = defaultMaxSignedBody
+	}
+	return &hmacAuth{o: o, seen: make(map[string]time.Time)}
+}
+
+// Authenticate implements Authenticator.
+func (a *hmacAuth) Authenticate(ctx *Context) (*Identity, error) {
+	req := ctx.Request
+
+	scheme, params, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, hmacScheme) {
+		return nil, ErrNoCredentials
+	}
+	keyID, sig := authParams(params)
+	if keyID == "" || sig == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if date.Before(now.Add(-a.o.Window)) || date.After(now.Add(a.o.Window)) {
+		return nil, ErrReplay
+	}
+
+	secret, id, err := a.o.Keys.Secret(req.Context(), keyID)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(req.Body, a.o.MaxBody+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > a.o.MaxBody {
+			return nil, ErrInvalidCredentials
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, req, body))) {
+		return nil, ErrInvalidCredentials
+	}
+	if !a.first(sig, now) {
+		return nil, ErrReplay
+	}
+
+	id.Scheme = "hmac"
+	return id, nil
+}
+
+// first records the signature sig, reporting whether it wasn't seen within
+// the replay window.
+func (a *hmacAuth) first(sig string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Signatures older than twice the window can't be replayed since their
+	// date is outside the window.
+	if now.Sub(a.swept) > a.o.Window {
+		for s, t := range a.seen {
+			if now.Sub(t) > 2*a.o.Window {
+				delete(a.seen, s)
+			}
+		}
+		a.swept = now
+	}
+
+	if _, ok := a.seen[sig]; ok {
+		return false
+	}
+	a.seen[sig] = now
+	return true
+}
+
+// Challenge implements Authenticator.
+func (a *hmacAuth) Challenge(err error) string {
+	return hmacScheme
+}
+
+// authParams returns the keyId and signature parameters of the Authorization
+// header.
+func authParams(params string) (keyID, sig string) {
+	for _, p := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+
+		switch k {
+		case "keyId":
+			keyID = v
+		case "signature":
+			sig = v
+		}
+	}
+	return keyID, sig
+}
@@ -0,0 +1,192 @@
+package cobalt
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+)
+
+type (
+	// IPFilterOptions configures an IPFilter.
+	IPFilterOptions struct {
+		// Allow are the networks clients may connect from. When it is empty
+		// all clients not denied are allowed.
+		Allow []netip.Prefix
+
+		// Deny are the networks clients may not connect from. The most
+		// specific network matching a client decides, so a client of a
+		// denied network within an allowed one is denied and the other way
+		// around. A network both allowed and denied is denied.
+		Deny []netip.Prefix
+
+		// Body is encoded with the Coder and served with a 403 status to
+		// denied clients. It defaults to a message saying access is
+		// forbidden.
+		Body interface{}
+
+		// OnDenied is called after a denied request is answered, for example
+		// to log it.
+		OnDenied func(ctx *Context)
+	}
+
+	// IPFilter allows or denies clients by their IP address. The networks
+	// can be replaced at runtime with Reload.
+	IPFilter struct {
+		o IPFilterOptions
+
+		mu   sync.RWMutex
+		trie *ipTrie
+	}
+
+	// ipTrie is a binary trie of networks, one bit of the address per level,
+	// so matching an address takes at most one step per bit.
+	ipTrie struct {
+		v4, v6 ipNode
+		allow  bool
+	}
+
+	// ipNode is a node of an ipTrie. Nodes at the end of a network are set
+	// and record whether the network is denied.
+	ipNode struct {
+		children [2]*ipNode
+		set      bool
+		deny     bool
+	}
+)
+
+// ipFilterBody is the default body served to denied clients.
+type ipFilterBody struct {
+	Error string
+}
+
+// NewIPFilter creates an IPFilter.
+func NewIPFilter(o IPFilterOptions) *IPFilter {
+	if o.Body == nil {
+		o.Body = ipFilterBody{Error: "forbidden"}
+	}
+	return &IPFilter{o: o, trie: newIPTrie(o.Allow, o.Deny)}
+}
+
+// Reload replaces the networks of the filter. Requests already being checked
+// use the previous networks.
+func (f *IPFilter) Reload(allow, deny []netip.Prefix) {
+	t := newIPTrie(allow, deny)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.trie = t
+}
+
+// Allowed reports whether clients from addr are allowed.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	f.mu.RLock()
+	t := f.trie
+	f.mu.RUnlock()
+
+	return t.allowed(addr)
+}
+
+// FilterIP returns middleware answering requests from clients not allowed by f
+// with 403 Forbidden. The client is the one resolved through the trusted
+// proxies. Clients whose address can't be parsed are only allowed when f has
+// no allowed networks.
+//
+// Example
+//
+//	vpn, _ := cobalt.ParseCIDRs("10.8.0.0/16", "fd00:8::/32")
+//	admin := c.Group("/admin", cobalt.FilterIP(cobalt.NewIPFilter(cobalt.IPFilterOptions{
+//		Allow: vpn,
+//	})))
+func FilterIP(f *IPFilter) MiddleWare {
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			addr, err := netip.ParseAddr(ctx.ClientIP())
+			if err != nil {
+				addr = netip.Addr{}
+			}
+
+			if !f.Allowed(addr) {
+				ctx.ServeWithStatus(f.o.Body, http.StatusForbidden)
+				if f.o.OnDenied != nil {
+					f.o.OnDenied(ctx)
+				}
+				return
+			}
+
+			h(ctx)
+		}
+	}
+}
+
+// newIPTrie creates a trie of the allowed and denied networks.
+func newIPTrie(allow, deny []netip.Prefix) *ipTrie {
+	t := ipTrie{allow: len(allow) > 0}
+	for _, p := range allow {
+		t.insert(p, false)
+	}
+	for _, p := range deny {
+		t.insert(p, true)
+	}
+	return &t
+}
+
+// root returns the root of the trie for addresses like addr.
+func (t *ipTrie) root(addr netip.Addr) *ipNode {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// insert adds the network p. Denying a network overrides allowing it.
+func (t *ipTrie) insert(p netip.Prefix, deny bool) {
+	if !p.IsValid() {
+		return
+	}
+	addr := p.Addr()
+	bits := p.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+
+	n := t.root(addr)
+	b := addr.AsSlice()
+	for i := 0; i < bits; i++ {
+		bit := b[i/8] >> (7 - i%8) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &ipNode{}
+		}
+		n = n.children[bit]
+	}
+
+	n.deny = n.deny || deny
+	n.set = true
+}
+
+// allowed reports whether addr is allowed by the most specific network
+// matching it.
+func (t *ipTrie) allowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return !t.allow
+	}
+	addr = addr.Unmap()
+
+	var match *ipNode
+	n := t.root(addr)
+	b := addr.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.set {
+			match = n
+		}
+		if i == len(b)*8 {
+			break
+		}
+		n = n.children[b[i/8]>>(7-i%8)&1]
+	}
+
+	if match == nil {
+		return !t.allow
+	}
+	return !match.deny
+}
@@ -0,0 +1,105 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// cidrs parses networks, failing the test on errors.
+func cidrs(t *testing.T, s ...string) []netip.Prefix {
+	t.Helper()
+	p, err := cobalt.ParseCIDRs(s...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestIPFilterAllowed tests the most specific network decides.
+func TestIPFilterAllowed(t *testing.T) {
+	f := cobalt.NewIPFilter(cobalt.IPFilterOptions{
+		Allow: cidrs(t, "10.0.0.0/8", "10.6.6.6", "2001:db8::/32", "192.0.2.0/24"),
+		Deny:  cidrs(t, "10.6.0.0/16", "2001:db8:bad::/48", "192.0.2.0/24"),
+	})
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"10.6.1.1", false},
+		{"10.6.6.6", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8:1::1", true},
+		{"2001:db8:bad::1", false},
+		{"2001:db9::1", false},
+		{"192.0.2.10", false},
+	}
+	for _, tt := range tests {
+		if allowed := f.Allowed(netip.MustParseAddr(tt.addr)); allowed != tt.allowed {
+			t.Errorf("%s: expected allowed to be %t", tt.addr, tt.allowed)
+		}
+	}
+
+	// Without allowed networks everything not denied is allowed.
+	f = cobalt.NewIPFilter(cobalt.IPFilterOptions{Deny: cidrs(t, "203.0.113.0/24")})
+	if !f.Allowed(netip.MustParseAddr("198.51.100.1")) || f.Allowed(netip.MustParseAddr("203.0.113.7")) {
+		t.Error("expected only the denied network to be denied")
+	}
+}
+
+// TestFilterIP tests a group is restricted to the client IPs resolved through
+// the trusted proxies and the networks can be reloaded.
+func TestFilterIP(t *testing.T) {
+	denied := 0
+	f := cobalt.NewIPFilter(cobalt.IPFilterOptions{
+		Allow:    cidrs(t, "172.16.0.0/12"),
+		OnDenied: func(ctx *cobalt.Context) { denied++ },
+	})
+
+	c := quiet()
+	c.Proxy.TrustedProxies = cidrs(t, "10.0.0.0/8")
+	handler := func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}
+	c.Get("/", handler)
+	admin := c.Group("/admin", cobalt.FilterIP(f))
+	admin.Get("/users", handler)
+
+	from := func(path, remote, forwarded string) *httptest.ResponseRecorder {
+		r := request("GET", path, nil, "X-Forwarded-For", forwarded)
+		r.RemoteAddr = remote
+		return do(c, r)
+	}
+
+	if w := from("/admin/users", "172.16.1.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the VPN client to be allowed, got %d", w.Code)
+	}
+	if w := from("/admin/users", "10.0.0.1:1234", "172.16.1.1"); w.Code != http.StatusOK {
+		t.Errorf("expected the VPN client behind the proxy to be allowed, got %d", w.Code)
+	}
+	w := from("/admin/users", "198.51.100.1:1234", "172.16.1.1")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "forbidden") {
+		t.Errorf("expected the spoofed client to be denied, got %d %s", w.Code, w.Body.String())
+	}
+	if denied != 1 {
+		t.Errorf("expected OnDenied to be called, got %d", denied)
+	}
+	if w := from("/", "198.51.100.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("expected routes outside the group not to be filtered, got %d", w.Code)
+	}
+
+	f.Reload(cidrs(t, "198.51.100.0/24"), nil)
+	if w := from("/admin/users", "198.51.100.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the reloaded network to be allowed, got %d", w.Code)
+	}
+	if w := from("/admin/users", "172.16.1.1:1234", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected the previous network to be denied, got %d", w.Code)
+	}
+}
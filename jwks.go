@@ -0,0 +1,321 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults for JWKS.
+const (
+	defaultJWKSRefresh    = time.Hour
+	defaultJWKSMinRefresh = time.Minute
+	maxJWKSSize           = 1 << 20
+)
+
+type (
+	// JWKSOptions configures a JWKS.
+	JWKSOptions struct {
+		// URL is the address the key set is fetched from.
+		URL string
+
+		// File is the path the key set is read from when there is no URL.
+		File string
+
+		// Client fetches the key set. It defaults to a client with a 10
+		// second timeout.
+		Client *http.Client
+
+		// Refresh is how long the keys are used before loading them again.
+		// It defaults to 1 hour.
+		Refresh time.Duration
+
+		// MinRefresh is how long the keys are used before loading them
+		// again when a token has an unknown key ID, such as after the keys
+		// were rotated. It defaults to 1 minute, so tokens with made up key
+		// IDs can't flood the source of the keys.
+		MinRefresh time.Duration
+	}
+
+	// JWKS is a KeySet loaded from a JSON Web Key Set document of RFC 7517.
+	// The keys are loaded when first needed and refreshed as they get old.
+	// Known keys are used while newer ones load, and when loading fails.
+	JWKS struct {
+		o JWKSOptions
+
+		mu     sync.Mutex
+		keys   map[string]jwk
+		loaded time.Time
+
+		// loading is closed when the load in progress ends, and nil when
+		// the keys aren't loading. err is the error of the last load.
+		loading chan struct{}
+		err     error
+	}
+
+	// jwk is a key of a key set.
+	jwk struct {
+		alg string
+		key interface{}
+	}
+
+	// jwkJSON is the JSON encoding of a key.
+	jwkJSON struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		K   string `json:"k"`
+	}
+)
+
+// NewJWKS creates a JWKS.
+func NewJWKS(o JWKSOptions) *JWKS {
+	if o.URL == "" && o.File == "" {
+		panic("cobalt: JWKS requires a URL or a File")
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.Refresh == 0 {
+		o.Refresh = defaultJWKSRefresh
+	}
+	if o.MinRefresh == 0 {
+		o.MinRefresh = defaultJWKSMinRefresh
+	}
+	return &JWKS{o: o}
+}
+
+// Key implements KeySet.
+func (s *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	age := time.Since(s.loaded)
+	k, ok := s.lookup(kid)
+	var loading chan struct{}
+	if s.keys == nil || age >= s.o.Refresh || (!ok && age >= s.o.MinRefresh) {
+		loading = s.startLoad()
+	}
+	s.mu.Unlock()
+
+	// Only requests needing a key that isn't known yet wait for the load.
+	if !ok && loading != nil {
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		s.mu.Lock()
+		k, ok = s.lookup(kid)
+		keys, err := s.keys, s.err
+		s.mu.Unlock()
+		if keys == nil && err != nil {
+			return nil, err
+		}
+	}
+
+	if !ok || (k.alg != "" && k.alg != alg) {
+		return nil, ErrUnknownKey
+	}
+	return k.key, nil
+}
+
+// Refresh loads the keys now, or waits for the load in progress.
+func (s *JWKS) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	loading := s.startLoad()
+	s.mu.Unlock()
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// lookup returns the key with the ID kid. Tokens without a key ID are
+// verified with the only key of the set.
+func (s *JWKS) lookup(kid string) (jwk, bool) {
+	if k, ok := s.keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	return jwk{}, false
+}
+
+// startLoad loads the keys in a goroutine, unless they are loading already,
+// and returns a channel closed when the load ends. The keys are shared by
+// all requests, so they are loaded without the context of any of them, within
+// the timeout of the client. s.mu must be held.
+func (s *JWKS) startLoad() chan struct{} {
+	if s.loading != nil {
+		return s.loading
+	}
+
+	loading := make(chan struct{})
+	s.loading = loading
+	go func() {
+		defer close(loading)
+		keys, err := s.load()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// The time of the attempt is recorded even when it fails, so a
+		// failing source isn't retried on every request.
+		s.loaded = time.Now()
+		s.err = err
+		if err == nil {
+			s.keys = keys
+		}
+		s.loading = nil
+	}()
+	return loading
+}
+
+// load reads and parses the keys.
+func (s *JWKS) load() (map[string]jwk, error) {
+	data, err := s.read(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// read reads the key set document.
+func (s *JWKS) read(ctx context.Context) ([]byte, error) {
+	if s.o.URL == "" {
+		return os.ReadFile(s.o.File)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.o.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cobalt: fetching key set: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// parseJWKS parses a key set document. Keys of unsupported types and
+// encryption keys are skipped.
+func parseJWKS(data []byte) (map[string]jwk, error) {
+	var doc struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jwk)
+	for _, k := range doc.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = jwk{alg: k.Alg, key: key}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("cobalt: key set has no usable keys")
+	}
+	return keys, nil
+}
+
+// parse returns the key in the form verifySignature expects.
+func (k jwkJSON) parse() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeB64(k.K)
+
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("cobalt: invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			break
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := pub.ECDH(); err != nil {
+			return nil, errors.New("cobalt: invalid EC key")
+		}
+		return &pub, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			break
+		}
+		x, err := decodeB64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("cobalt: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("cobalt: unsupported key type %s %s", k.Kty, k.Crv)
+}
+
+// decodeB64 decodes a base64url encoded value of a key.
+func decodeB64(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("cobalt: missing key value")
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
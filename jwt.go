@@ -0,0 +1,412 @@
+package cobalt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signature algorithms of JSON Web Tokens.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Errors of token verification.
+var (
+	// ErrTokenMissing is returned when a request has no bearer token. It
+	// wraps ErrNoCredentials.
+	ErrTokenMissing = fmt.Errorf("%w: missing bearer token", ErrNoCredentials)
+
+	// ErrTokenMalformed is returned for tokens that can't be decoded.
+	ErrTokenMalformed = errors.New("cobalt: malformed token")
+
+	// ErrTokenSignature is returned for tokens signed with an algorithm
+	// that isn't accepted or whose signature doesn't match.
+	ErrTokenSignature = errors.New("cobalt: invalid token signature")
+
+	// ErrTokenExpired is returned for tokens past their expiry and tokens
+	// without an exp claim.
+	ErrTokenExpired = errors.New("cobalt: token expired")
+
+	// ErrTokenNotYetValid is returned for tokens used before their nbf
+	// claim.
+	ErrTokenNotYetValid = errors.New("cobalt: token not valid yet")
+
+	// ErrTokenClaims is returned for tokens of another issuer or audience.
+	ErrTokenClaims = errors.New("cobalt: invalid token claims")
+
+	// ErrUnknownKey is returned by key sets without the key of a token.
+	ErrUnknownKey = errors.New("cobalt: unknown key")
+)
+
+type (
+	// KeySet provides the keys verifying the signatures of tokens.
+	KeySet interface {
+		// Key returns the key with the ID kid for the algorithm alg. kid is
+		// empty for tokens without a key ID. The key is a []byte for HS256,
+		// a *rsa.PublicKey for RS256, a *ecdsa.PublicKey for ES256 and an
+		// ed25519.PublicKey for EdDSA.
+		Key(ctx context.Context, kid, alg string) (interface{}, error)
+	}
+
+	// StaticKeys is a KeySet of keys by their ID. Tokens without a key ID
+	// are verified with the key of the empty ID, or the only key of the set.
+	StaticKeys map[string]interface{}
+
+	// JWTOptions configures the JWT middleware.
+	JWTOptions struct {
+		// Keys verify the signatures of tokens. It is required.
+		Keys KeySet
+
+		// Algorithms are the signature algorithms accepted. It defaults to
+		// HS256, RS256, ES256 and EdDSA. Tokens are only verified with keys
+		// of the type of their algorithm, whatever the list.
+		Algorithms []string
+
+		// Issuer is the iss claim tokens must have, if set.
+		Issuer string
+
+		// Audience is a value the aud claim of tokens must contain, if set.
+		Audience string
+
+		// ClockSkew is the leeway allowed when checking the exp and nbf
+		// claims, for clocks of servers being out of sync.
+		ClockSkew time.Duration
+
+		// Optional lets requests without a token through without claims, so
+		// handlers or authorization policies can decide. Requests with an
+		// invalid token are still rejected.
+		Optional bool
+
+		// Extract returns the token of a request. It defaults to the bearer
+		// token of the Authorization header.
+		Extract func(ctx *Context) string
+
+		// Realm is sent in the WWW-Authenticate header of rejected requests.
+		Realm string
+
+		// Body is encoded with the Coder and served with a 401 status to
+		// rejected requests. It defaults to a message saying the request is
+		// unauthorized.
+		Body interface{}
+
+		// OnError is called after a rejected request is answered with the
+		// reason, for example to log it.
+		OnError func(ctx *Context, err error)
+	}
+
+	// Claims are the claims of a verified token.
+	Claims struct {
+		Issuer    string      `json:"iss,omitempty"`
+		Subject   string      `json:"sub,omitempty"`
+		Audience  Audience    `json:"aud,omitempty"`
+		ExpiresAt NumericDate `json:"exp,omitempty"`
+		NotBefore NumericDate `json:"nbf,omitempty"`
+		IssuedAt  NumericDate `json:"iat,omitempty"`
+		ID        string      `json:"jti,omitempty"`
+
+		// Scope is the space separated list of scopes granted to the
+		// token.
+		Scope string `json:"scope,omitempty"`
+
+		// Roles are the roles of the subject, as set by many identity
+		// providers.
+		Roles []string `json:"roles,omitempty"`
+
+		// Raw is the JSON payload of the token, with all its claims.
+		Raw json.RawMessage `json:"-"`
+	}
+
+	// Audience is the aud claim, which is a string or an array of strings.
+	Audience []string
+
+	// NumericDate is a time in seconds since the Unix epoch.
+	NumericDate int64
+
+	// jwtHeader is the header of a token.
+	jwtHeader struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+)
+
+// Key implements KeySet.
+func (s StaticKeys) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	if k, ok := s[kid]; ok {
+		return k, nil
+	}
+	if kid == "" && len(s) == 1 {
+		for _, k := range s {
+			return k, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether the audience contains aud.
+func (a Audience) Contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fractions of seconds are
+// dropped.
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	*d = NumericDate(math.Floor(f))
+	return nil
+}
+
+// Time returns the date as a time, or the zero time if it is not set.
+func (d NumericDate) Time() time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(d), 0)
+}
+
+// Decode decodes all the claims into val, a pointer to a struct of the
+// claims of the application.
+func (c *Claims) Decode(val interface{}) error {
+	return json.Unmarshal(c.Raw, val)
+}
+
+// Scopes returns the scopes granted to the token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Claims returns the claims of the token the request was authenticated with,
+// or nil if it wasn't.
+func (c *Context) Claims() *Claims {
+	return c.claims
+}
+
+// JWT returns middleware authenticating requests with a JSON Web Token. The
+// claims of valid tokens are available to handlers with Context.Claims, other
+// requests are answered with 401 Unauthorized, or 500 Internal Server Error
+// when the keys couldn't be loaded. Tokens must have an exp claim, tokens that
+// never expire are rejected.
+//
+// Example
+//
+//	keys := cobalt.NewJWKS(cobalt.JWKSOptions{URL: "https://auth.example.com/.well-known/jwks.json"})
+//	api := c.Group("/api", cobalt.JWT(cobalt.JWTOptions{
+//		Keys:      keys,
+//		Issuer:    "https://auth.example.com/",
+//		Audience:  "orders",
+//		ClockSkew: 30 * time.Second,
+//	}))
+func JWT(o JWTOptions) MiddleWare {
+	return Authenticate(Bearer(o), AuthOptions{Optional: o.Optional, Body: o.Body, OnError: o.OnError})
+}
+
+// Bearer returns an Authenticator of requests with a JSON Web Token, for use
+// with other schemes in AnyOf. The identity is named after the subject of the
+// token and has its roles and scopes. The Optional, Body and OnError options
+// only apply to the JWT middleware.
+func Bearer(o JWTOptions) Authenticator {
+	if o.Keys == nil {
+		panic("cobalt: JWT requires a KeySet")
+	}
+	if len(o.Algorithms) == 0 {
+		o.Algorithms = []string{HS256, RS256, ES256, EdDSA}
+	}
+	if o.Extract == nil {
+		o.Extract = bearerToken
+	}
+	return bearerAuth{o: o}
+}
+
+// bearerAuth authenticates requests with JSON Web Tokens.
+type bearerAuth struct {
+	o JWTOptions
+}
+
+// Authenticate implements Authenticator.
+func (a bearerAuth) Authenticate(ctx *Context) (*Identity, error) {
+	claims, err := a.o.verify(ctx.Request.Context(), a.o.Extract(ctx), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.claims = claims
+	return &Identity{Name: claims.Subject, Scheme: "bearer", Roles: claims.Roles, Scopes: claims.Scopes(), Claims: claims}, nil
+}
+
+// Challenge implements Authenticator.
+func (a bearerAuth) Challenge(err error) string {
+	var params []string
+	if a.o.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", a.o.Realm))
+	}
+	switch err {
+	case ErrTokenMalformed, ErrTokenSignature, ErrTokenExpired, ErrTokenNotYetValid, ErrTokenClaims, ErrUnknownKey:
+		params = append(params, `error="invalid_token"`)
+	}
+
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	return challenge
+}
+
+// bearerToken returns the bearer token of the Authorization header.
+func bearerToken(ctx *Context) string {
+	scheme, token, ok := strings.Cut(ctx.Request.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// verify verifies the signature and claims of token at now.
+func (o JWTOptions) verify(ctx context.Context, token string, now time.Time) (*Claims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var hdr jwtHeader
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !o.accepts(hdr.Alg) {
+		return nil, ErrTokenSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	key, err := o.Keys.Key(ctx, hdr.Kid, hdr.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], sig) {
+		return nil, ErrTokenSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := Claims{Raw: payload}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	switch {
+	case claims.ExpiresAt == 0 || !now.Before(claims.ExpiresAt.Time().Add(o.ClockSkew)):
+		return nil, ErrTokenExpired
+	case claims.NotBefore != 0 && now.Add(o.ClockSkew).Before(claims.NotBefore.Time()):
+		return nil, ErrTokenNotYetValid
+	case o.Issuer != "" && claims.Issuer != o.Issuer:
+		return nil, ErrTokenClaims
+	case o.Audience != "" && !claims.Audience.Contains(o.Audience):
+		return nil, ErrTokenClaims
+	}
+
+	return &claims, nil
+}
+
+// accepts reports whether tokens signed with alg are accepted.
+func (o JWTOptions) accepts(alg string) bool {
+	for _, a := range o.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token.
+func decodeSegment(s string, val interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, val)
+}
+
+// verifySignature verifies the signature of the signed part of a token. The
+// key must be of the type of the algorithm, so a public key can't be used as
+// an HMAC secret.
+func verifySignature(alg string, key interface{}, signed string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(sig, mac.Sum(nil))
+
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, []byte(signed), sig)
+	}
+
+	return false
+}
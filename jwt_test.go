@@ -0,0 +1,343 @@
+package cobalt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// signToken signs a token with the private key of the algorithm.
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	hdr := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		hdr["kid"] = kid
+	}
+	h, _ := json.Marshal(hdr)
+	p, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// claims returns claims of a token expiring in an hour.
+func claims(extra ...interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss": "https://auth.example.com/",
+		"sub": "user-1",
+		"aud": "orders",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for i := 0; i < len(extra); i += 2 {
+		c[extra[i].(string)] = extra[i+1]
+	}
+	return c
+}
+
+// jwtApp returns a cobalt serving the subject of the token at /.
+func jwtApp(o cobalt.JWTOptions) *cobalt.Cobalt {
+	c := quiet()
+	c.Get("/", func(ctx *cobalt.Context) {
+		sub := "anonymous"
+		if cl := ctx.Claims(); cl != nil {
+			sub = cl.Subject
+		}
+		ctx.ServeResponse([]byte(sub), http.StatusOK, "text/plain")
+	}, cobalt.JWT(o))
+	return c
+}
+
+// TestJWTAlgorithms tests tokens of every algorithm are verified with keys
+// of their type only.
+func TestJWTAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	c := jwtApp(cobalt.JWTOptions{Keys: cobalt.StaticKeys{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	}})
+
+	tests := []struct {
+		alg, kid string
+		key      interface{}
+	}{
+		{cobalt.HS256, "hs", secret},
+		{cobalt.RS256, "rs", rsaKey},
+		{cobalt.ES256, "es", ecKey},
+		{cobalt.EdDSA, "ed", edKey},
+	}
+	for _, tt := range tests {
+		w := get(c, "/", "Authorization", "Bearer "+signToken(t, tt.alg, tt.kid, tt.key, claims()))
+		if w.Code != http.StatusOK || w.Body.String() != "user-1" {
+			t.Errorf("%s: expected the token to be verified, got %d %s", tt.alg, w.Code, w.Body.String())
+		}
+	}
+
+	// A token signed with the public key as an HMAC secret is rejected.
+	pub, _ := json.Marshal(rsaKey.PublicKey)
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.HS256, "rs", pub, claims())); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected algorithm confusion to be rejected, got %d", w.Code)
+	}
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.HS256, "ed", []byte("guess"), claims())); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bad signature to be rejected, got %d", w.Code)
+	}
+
+	// Only the configured algorithms are accepted.
+	c = jwtApp(cobalt.JWTOptions{Keys: cobalt.StaticKeys{"hs": secret}, Algorithms: []string{cobalt.RS256}})
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.HS256, "hs", secret, claims())); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the algorithm not to be accepted, got %d", w.Code)
+	}
+}
+
+// TestJWTClaims tests the time, issuer and audience claims are validated.
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("secret")
+	var failed error
+	c := jwtApp(cobalt.JWTOptions{
+		Keys:      cobalt.StaticKeys{"": secret},
+		Issuer:    "https://auth.example.com/",
+		Audience:  "orders",
+		ClockSkew: time.Minute,
+		Realm:     "api",
+		OnError:   func(ctx *cobalt.Context, err error) { failed = err },
+	})
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"valid", claims(), nil},
+		{"expired within skew", claims("exp", now.Add(-30*time.Second).Unix()), nil},
+		{"expired", claims("exp", now.Add(-2*time.Minute).Unix()), cobalt.ErrTokenExpired},
+		{"no expiry", claims("exp", 0), cobalt.ErrTokenExpired},
+		{"not before within skew", claims("nbf", now.Add(30*time.Second).Unix()), nil},
+		{"not yet valid", claims("nbf", now.Add(2*time.Minute).Unix()), cobalt.ErrTokenNotYetValid},
+		{"fractional dates", claims("exp", float64(now.Add(time.Hour).Unix())+0.5), nil},
+		{"issuer", claims("iss", "https://evil.example.com/"), cobalt.ErrTokenClaims},
+		{"audience list", claims("aud", []string{"billing", "orders"}), nil},
+		{"audience", claims("aud", []string{"billing"}), cobalt.ErrTokenClaims},
+	}
+	for _, tt := range tests {
+		failed = nil
+		w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.HS256, "", secret, tt.claims))
+		if tt.err == nil && w.Code != http.StatusOK {
+			t.Errorf("%s: expected the token to be valid, got %d %v", tt.name, w.Code, failed)
+		}
+		if tt.err != nil && (w.Code != http.StatusUnauthorized || failed != tt.err) {
+			t.Errorf("%s: expected %v, got %d %v", tt.name, tt.err, w.Code, failed)
+		}
+	}
+
+	w := get(c, "/")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` || !strings.Contains(w.Body.String(), "unauthorized") {
+		t.Errorf("expected a bearer challenge, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	token := signToken(t, cobalt.HS256, "", secret, claims())
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2]
+	for _, token := range []string{forged, "not.a.token", "one-part"} {
+		w := get(c, "/", "Authorization", "Bearer "+token)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+			t.Errorf("%s: expected the token to be rejected, got %d %v", token, w.Code, w.Header())
+		}
+	}
+
+	// Optional authentication lets requests without a token through.
+	c = jwtApp(cobalt.JWTOptions{Keys: cobalt.StaticKeys{"": secret}, Optional: true})
+	if w := get(c, "/"); w.Code != http.StatusOK || w.Body.String() != "anonymous" {
+		t.Errorf("expected an anonymous request, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get(c, "/", "Authorization", "Bearer "+forged); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected invalid tokens to be rejected, got %d", w.Code)
+	}
+}
+
+// TestJWTTypedClaims tests the claims of the application are decoded.
+func TestJWTTypedClaims(t *testing.T) {
+	type appClaims struct {
+		Tenant string   `json:"tenant"`
+		Roles  []string `json:"roles"`
+	}
+
+	var got appClaims
+	var scopes []string
+	c := quiet()
+	c.Get("/", func(ctx *cobalt.Context) {
+		if err := ctx.Claims().Decode(&got); err != nil {
+			t.Error(err)
+		}
+		scopes = ctx.Claims().Scopes()
+	}, cobalt.JWT(cobalt.JWTOptions{Keys: cobalt.StaticKeys{"": []byte("secret")}}))
+
+	get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.HS256, "", []byte("secret"), claims("tenant", "acme", "roles", []string{"admin"}, "scope", "orders:read orders:write")))
+	if got.Tenant != "acme" || len(got.Roles) != 1 || len(scopes) != 2 {
+		t.Errorf("expected the typed claims, got %+v %v", got, scopes)
+	}
+}
+
+// TestJWKSSlowRefresh tests requests are verified with the known keys while
+// the key set is refreshed, and the key set is fetched once for all of them.
+func TestJWKSSlowRefresh(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	release := make(chan struct{})
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{jwk("rs-1", &rsaKey.PublicKey)}})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := jwtApp(cobalt.JWTOptions{Keys: cobalt.NewJWKS(cobalt.JWKSOptions{URL: srv.URL, Refresh: 10 * time.Millisecond})})
+	token := "Bearer " + signToken(t, cobalt.RS256, "rs-1", rsaKey, claims())
+	if w := get(c, "/", "Authorization", token); w.Code != http.StatusOK {
+		t.Fatalf("expected the token to be verified, got %d", w.Code)
+	}
+
+	// The refresh is blocked until the end of the test.
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 100 && atomic.LoadInt32(&fetches) < 2; i++ {
+		if w := get(c, "/", "Authorization", token); w.Code != http.StatusOK {
+			t.Fatalf("expected the token to be verified with the known key, got %d", w.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if w := get(c, "/", "Authorization", token); w.Code != http.StatusOK {
+			t.Fatalf("expected the token to be verified with the known key, got %d", w.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected one refresh of the key set, got %d fetches", n-1)
+	}
+}
+
+// jwk encodes a public key as a JSON Web Key.
+func jwk(kid string, key interface{}) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "alg": "RS256", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	return nil
+}
+
+// TestJWKS tests key sets are fetched, refreshed when a token has an unknown
+// key ID and read from files.
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := []map[string]string{jwk("rs-1", &rsaKey.PublicKey), {"kty": "RSA", "kid": "enc", "use": "enc"}}
+	var mu sync.Mutex
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer srv.Close()
+
+	c := jwtApp(cobalt.JWTOptions{Keys: cobalt.NewJWKS(cobalt.JWKSOptions{URL: srv.URL, MinRefresh: 10 * time.Millisecond})})
+
+	for i := 0; i < 3; i++ {
+		if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.RS256, "rs-1", rsaKey, claims())); w.Code != http.StatusOK {
+			t.Fatalf("expected the token to be verified, got %d", w.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the key set to be fetched once, got %d", n)
+	}
+
+	// The key of an RS256 key can't verify ES256 tokens.
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.ES256, "rs-1", ecKey, claims())); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the algorithm of the key to be enforced, got %d", w.Code)
+	}
+
+	// Rotated keys are fetched.
+	mu.Lock()
+	keys = append(keys, jwk("es-2", &ecKey.PublicKey))
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.ES256, "es-2", ecKey, claims())); w.Code != http.StatusOK {
+		t.Errorf("expected the rotated key to be fetched, got %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected the key set to be fetched again, got %d", n)
+	}
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	doc, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{jwk("ed", edPub)}})
+	if err := os.WriteFile(file, doc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c = jwtApp(cobalt.JWTOptions{Keys: cobalt.NewJWKS(cobalt.JWKSOptions{File: file})})
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.EdDSA, "", edKey, claims())); w.Code != http.StatusOK {
+		t.Errorf("expected the token to be verified with the key of the file, got %d", w.Code)
+	}
+
+	c = jwtApp(cobalt.JWTOptions{Keys: cobalt.NewJWKS(cobalt.JWKSOptions{File: filepath.Join(t.TempDir(), "missing.json")})})
+	if w := get(c, "/", "Authorization", "Bearer "+signToken(t, cobalt.EdDSA, "", edKey, claims())); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a server error without keys, got %d", w.Code)
+	}
+}
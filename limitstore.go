@@ -0,0 +1,120 @@
+package cobalt
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// memoryShards is the number of shards of a MemoryStore.
+const memoryShards = 64
+
+// sweepEvery is the number of updates of a shard between sweeps of its
+// expired keys.
+const sweepEvery = 1024
+
+type (
+	// RateLimitStore keeps the state of rate limiters. Stores shared by
+	// several instances of a service, for example backed by Redis, let them
+	// enforce a common limit.
+	RateLimitStore interface {
+		// Update calls f with the state stored for key, or nil if there is
+		// none or it expired, and the current time. The state returned by f
+		// is stored and expires after ttl. Updates of a key must be atomic;
+		// stores may call f again if the state changed concurrently.
+		Update(ctx context.Context, key string, ttl time.Duration, f func(state []byte, now time.Time) []byte) error
+	}
+
+	// MemoryStore is a RateLimitStore keeping state in memory. Keys are
+	// spread over shards with their own lock so concurrent requests rarely
+	// contend. Expired keys are evicted as the store is used.
+	MemoryStore struct {
+		maxKeys int
+		shards  [memoryShards]memoryShard
+	}
+
+	// memoryShard is a part of a MemoryStore.
+	memoryShard struct {
+		mu      sync.Mutex
+		entries map[string]memoryEntry
+		updates int
+	}
+
+	// memoryEntry is the state stored for a key.
+	memoryEntry struct {
+		state   []byte
+		expires time.Time
+	}
+)
+
+// NewMemoryStore creates a MemoryStore. When maxKeys is more than zero the
+// store holds at most about maxKeys keys, evicting keys at random when full so
+// a flood of distinct keys can't exhaust memory.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	s := MemoryStore{maxKeys: maxKeys}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]memoryEntry)
+	}
+	return &s
+}
+
+// Update implements RateLimitStore.
+func (s *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration, f func(state []byte, now time.Time) []byte) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sh := &s.shards[h.Sum32()%memoryShards]
+
+	now := time.Now()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.updates++
+	if sh.updates%sweepEvery == 0 {
+		sh.sweep(now)
+	}
+
+	e, ok := sh.entries[key]
+	if ok && !now.Before(e.expires) {
+		ok = false
+	}
+
+	var state []byte
+	if ok {
+		state = e.state
+	} else if s.maxKeys > 0 && len(sh.entries) >= (s.maxKeys+memoryShards-1)/memoryShards {
+		sh.sweep(now)
+		for k := range sh.entries {
+			if len(sh.entries) < (s.maxKeys+memoryShards-1)/memoryShards {
+				break
+			}
+			delete(sh.entries, k)
+		}
+	}
+
+	sh.entries[key] = memoryEntry{state: f(state, now), expires: now.Add(ttl)}
+	return nil
+}
+
+// Len returns the number of keys in the store, including expired keys not
+// evicted yet.
+func (s *MemoryStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// sweep evicts the expired keys of the shard.
+func (sh *memoryShard) sweep(now time.Time) {
+	for k, e := range sh.entries {
+		if !now.Before(e.expires) {
+			delete(sh.entries, k)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cobalt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Field is a key value pair attached to a log entry.
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+
+	// Logger is the interface used by cobalt to write structured logs. It can
+	// be set through the Logger field of Cobalt. NewStdLogger and
+	// NewSlogLogger adapt the standard library loggers.
+	Logger interface {
+		Info(msg string, fields ...Field)
+		Error(msg string, fields ...Field)
+
+		// With returns a Logger that adds fields to every entry.
+		With(fields ...Field) Logger
+	}
+)
+
+// defaultLogger is used when no Logger is configured. It writes to stdout
+// with the same prefix cobalt has always used.
+var defaultLogger = NewStdLogger(log.New(os.Stdout, "[cobalt] ", 0))
+
+// stdLogger adapts a *log.Logger.
+type stdLogger struct {
+	l      *log.Logger
+	fields []Field
+}
+
+// NewStdLogger returns a Logger that writes entries to l as the message
+// followed by key=value pairs.
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l: l}
+}
+
+// Info implements Logger.
+func (s stdLogger) Info(msg string, fields ...Field) {
+	s.print(msg, fields)
+}
+
+// Error implements Logger.
+func (s stdLogger) Error(msg string, fields ...Field) {
+	s.print("ERROR "+msg, fields)
+}
+
+// With implements Logger.
+func (s stdLogger) With(fields ...Field) Logger {
+	f := make([]Field, 0, len(s.fields)+len(fields))
+	s.fields = append(append(f, s.fields...), fields...)
+	return s
+}
+
+// print writes msg and the fields as one line.
+func (s stdLogger) print(msg string, fields []Field) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, fs := range [][]Field{s.fields, fields} {
+		for _, f := range fs {
+			v := fmt.Sprint(f.Value)
+			if v == "" || strings.ContainsAny(v, " \t\r\n\"=") {
+				v = strconv.Quote(v)
+			}
+			b.WriteString(" ")
+			b.WriteString(f.Key)
+			b.WriteString("=")
+			b.WriteString(v)
+		}
+	}
+	s.l.Print(b.String())
+}
+
+// slogLogger adapts a *slog.Logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that writes entries to l. Info and Error
+// are logged at slog.LevelInfo and slog.LevelError.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+// Info implements Logger.
+func (s slogLogger) Info(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelInfo, msg, attrs(fields)...)
+}
+
+// Error implements Logger.
+func (s slogLogger) Error(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelError, msg, attrs(fields)...)
+}
+
+// With implements Logger.
+func (s slogLogger) With(fields ...Field) Logger {
+	args := make([]interface{}, len(fields))
+	for i, a := range attrs(fields) {
+		args[i] = a
+	}
+	return slogLogger{l: s.l.With(args...)}
+}
+
+// attrs converts fields to slog attributes.
+func attrs(fields []Field) []slog.Attr {
+	a := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		a[i] = slog.Any(f.Key, f.Value)
+	}
+	return a
+}
+
+// logger returns the Logger configured for c.
+func (c *Cobalt) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return defaultLogger
+}
+
+// Logger returns the Logger for the request. Entries written to it carry the
+// request ID.
+func (c *Context) Logger() Logger {
+	if c.reqLogger == nil {
+		l := c.logger
+		if l == nil {
+			l = defaultLogger
+		}
+		c.reqLogger = l.With(Field{"request_id", c.ID})
+	}
+	return c.reqLogger
+}
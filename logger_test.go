@@ -0,0 +1,82 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestLoggerSlog tests request entries are written to a slog logger with
+// structured fields.
+func TestLoggerSlog(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+
+	var buf bytes.Buffer
+	c := cobalt.New(&JSONEncoder{})
+	c.Logger = cobalt.NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.Logger().Info("in handler", cobalt.Field{Key: "user", Value: "bill"})
+		ctx.ServeStatus(204)
+	})
+
+	c.ServeHTTP(w, r)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log entries, got %d: %s", len(lines), buf.String())
+	}
+
+	id := w.Header().Get("X-Request-Id")
+	want := []string{"request started", "in handler", "request completed"}
+	for i, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected JSON log entry, got %s", line)
+		}
+		if entry["msg"] != want[i] {
+			t.Errorf("expected entry %d to be %s, got %v", i, want[i], entry["msg"])
+		}
+		if entry["request_id"] != id {
+			t.Errorf("expected entry %d to have request_id %s, got %v", i, id, entry["request_id"])
+		}
+	}
+
+	var done map[string]interface{}
+	json.Unmarshal([]byte(lines[2]), &done)
+	if done["status"] != float64(204) || done["method"] != "GET" || done["remote_addr"] != "10.0.0.1:1234" {
+		t.Errorf("expected completion fields, got %s", lines[2])
+	}
+	if _, ok := done["latency"]; !ok {
+		t.Errorf("expected latency field, got %s", lines[2])
+	}
+}
+
+// TestLoggerDisableRequestLog tests turning off the request entries.
+func TestLoggerDisableRequestLog(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	var buf bytes.Buffer
+	c := cobalt.New(&JSONEncoder{})
+	c.Logger = cobalt.NewStdLogger(log.New(&buf, "", 0))
+	c.DisableRequestLog = true
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.Logger().Error("failed", cobalt.Field{Key: "reason", Value: "bad input"})
+	})
+
+	c.ServeHTTP(w, r)
+
+	want := `ERROR failed request_id=` + w.Header().Get("X-Request-Id") + ` reason="bad input"`
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("Got:  %s", got)
+		t.Errorf("Want: %s", want)
+	}
+}
@@ -0,0 +1,194 @@
+package cobalt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// errWriterClosed is returned when writing to a closed AsyncWriter.
+var errWriterClosed = errors.New("cobalt: write to closed writer")
+
+// AsyncWriter queues writes and writes them to an io.Writer from its own
+// goroutine, so callers such as AccessLog don't wait on slow output. Up to
+// size writes are queued before Write blocks. Close must be called to flush
+// the queued writes.
+type AsyncWriter struct {
+	w     io.Writer
+	queue chan []byte
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncWriter creates an AsyncWriter writing to w with room for size
+// queued writes.
+func NewAsyncWriter(w io.Writer, size int) *AsyncWriter {
+	a := AsyncWriter{
+		w:     w,
+		queue: make(chan []byte, size),
+		done:  make(chan struct{}),
+	}
+	go a.loop()
+	return &a
+}
+
+// loop writes the queued writes in order. Every write is passed on as is so
+// writers such as RotatingFile never see a line split in two.
+func (a *AsyncWriter) loop() {
+	defer close(a.done)
+
+	for p := range a.queue {
+		a.w.Write(p)
+	}
+}
+
+// Write queues a copy of p to be written.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return 0, errWriterClosed
+	}
+	a.queue <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// Close writes out everything queued and stops the writer. It does not close
+// the underlying io.Writer.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+	return nil
+}
+
+// RotatingFile is an io.WriteCloser appending to a file that is rotated once
+// it would grow past MaxSize bytes. Rotated files are renamed with a numeric
+// suffix, path.1 being the newest, and only MaxBackups of them are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+// OpenRotatingFile opens, or creates, the file at path for appending.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// open opens the file at path for appending.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would not fit. When
+// rotating fails p is still appended to the current file, and the error is
+// returned. If the file couldn't be opened again after rotating, it is opened
+// by the next writes.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var rerr error
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if rerr = r.rotate(); r.f == nil {
+			return 0, rerr
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err == nil {
+		err = rerr
+	}
+	return n, err
+}
+
+// rotate moves the file aside and starts a new one. The file at path is
+// opened again even when moving it fails, so logging goes on.
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	if err == nil {
+		err = r.shift()
+	}
+
+	if oerr := r.open(); oerr != nil {
+		r.f = nil
+		return oerr
+	}
+	return err
+}
+
+// shift renames the file to the first backup, shifting the backups along and
+// dropping the oldest. Without backups the file is removed.
+func (r *RotatingFile) shift() error {
+	if r.maxBackups <= 0 {
+		return os.Remove(r.path)
+	}
+
+	os.Remove(r.backup(r.maxBackups))
+	for i := r.maxBackups - 1; i > 0; i-- {
+		os.Rename(r.backup(i), r.backup(i+1))
+	}
+	return os.Rename(r.path, r.backup(1))
+}
+
+// backup returns the name of the nth backup.
+func (r *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
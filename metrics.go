@@ -0,0 +1,407 @@
+package cobalt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition
+// format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram buckets used by Metrics, in seconds, when
+// none are given.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelSep joins label values into the key of a series.
+const labelSep = "\xff"
+
+type (
+	// Registry holds metrics and writes them in the Prometheus text
+	// exposition format. It is safe for concurrent use.
+	Registry struct {
+		mu      sync.Mutex
+		metrics map[string]*metric
+		names   []string
+	}
+
+	// Counter is a value that only goes up, such as the number of requests
+	// served.
+	Counter struct {
+		m *metric
+	}
+
+	// Gauge is a value that goes up and down, such as the number of requests
+	// in flight.
+	Gauge struct {
+		m *metric
+	}
+
+	// Histogram counts observations, such as request durations, in
+	// configurable buckets.
+	Histogram struct {
+		m *metric
+	}
+
+	// metric is a named metric with one series per combination of label
+	// values.
+	metric struct {
+		name    string
+		help    string
+		kind    string
+		labels  []string
+		buckets []float64
+
+		mu     sync.Mutex
+		series map[string]*series
+	}
+
+	// series is the value of a metric for one combination of label values.
+	series struct {
+		labels []string
+		value  float64
+
+		// Histograms only.
+		counts []uint64
+		count  uint64
+	}
+)
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// Counter registers a counter with the given label names. It panics if the
+// name is invalid or already registered.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// Gauge registers a gauge with the given label names. It panics if the name
+// is invalid or already registered.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// Histogram registers a histogram with the given bucket upper bounds and
+// label names. DefaultBuckets are used when buckets is empty. It panics if the
+// name is invalid or already registered.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{r.register(name, help, "histogram", labels, b)}
+}
+
+// register adds a metric to the registry.
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+	if !validMetricName(name) {
+		panic("cobalt: invalid metric name " + strconv.Quote(name))
+	}
+	for _, l := range labels {
+		if !validMetricName(l) || strings.Contains(l, ":") || l == "le" {
+			panic("cobalt: invalid label name " + strconv.Quote(l) + " for metric " + name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic("cobalt: metric " + name + " is already registered")
+	}
+
+	m := metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.metrics[name] = &m
+	r.names = append(r.names, name)
+	sort.Strings(r.names)
+
+	return &m
+}
+
+// validMetricName reports whether name matches [a-zA-Z_:][a-zA-Z0-9_:]*.
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		switch b := name[i]; {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b == '_', b == ':':
+		case b >= '0' && b <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// update calls f with the series for the label values while holding the
+// lock of the metric. It panics if the number of values doesn't match the
+// label names.
+func (m *metric) update(values []string, f func(s *series)) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("cobalt: metric %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, labelSep)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	f(s)
+}
+
+// Inc adds one to the counter for the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter for the label
+// values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("cobalt: counter " + c.m.name + " can't decrease")
+	}
+	c.m.update(values, func(s *series) { s.value += v })
+}
+
+// Set sets the gauge for the label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.update(values, func(s *series) { s.value = v })
+}
+
+// Add adds v, which may be negative, to the gauge for the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.update(values, func(s *series) { s.value += v })
+}
+
+// Observe records v in the histogram for the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.update(values, func(s *series) {
+		s.value += v
+		s.count++
+		if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+			s.counts[i]++
+		}
+	})
+}
+
+// WriteTo writes all metrics to w in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]*metric, len(r.names))
+	for i, name := range r.names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	cw := countWriter{w: w}
+	bw := bufio.NewWriter(&cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// write writes the metric with its series sorted by label values.
+func (m *metric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			writeSample(w, m.name, m.labels, s.labels, "", s.value)
+			continue
+		}
+
+		var cumulative uint64
+		for i, b := range m.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, m.name+"_bucket", m.labels, s.labels, formatFloat(b), float64(cumulative))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.labels, "+Inf", float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.labels, "", s.value)
+		writeSample(w, m.name+"_count", m.labels, s.labels, "", float64(s.count))
+	}
+}
+
+// writeSample writes one sample line. The le label is added for histogram
+// buckets when le is not empty.
+func writeSample(w *bufio.Writer, name string, labels, values []string, le string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "le=\"%s\"", le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats v as the exposition format expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes the help text of a metric.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Handler returns a Handler serving the metrics in the Prometheus text
+// exposition format. It is added on the route metrics are scraped from.
+//
+// Example
+//
+//	admin.Get("/metrics", registry.Handler())
+func (r *Registry) Handler() Handler {
+	return func(ctx *Context) {
+		w := ctx.Response
+		w.Header().Set("Content-Type", metricsContentType)
+		w.WriteHeader(http.StatusOK)
+		ctx.Status = http.StatusOK
+		r.WriteTo(w)
+	}
+}
+
+// Metrics returns middleware recording the rate, errors and duration of
+// requests in reg. Requests are labelled with the method and the route
+// pattern, never the raw path, so the number of series stays bounded. The
+// duration histogram uses buckets, or DefaultBuckets when none are given.
+//
+// The following metrics are registered, so Metrics may only be called once
+// per Registry:
+//
+//	http_requests_total{method,route,status}     requests by status class, like "2xx"
+//	http_request_duration_seconds{method,route}  histogram of request durations
+//	http_requests_in_flight{method,route}        requests being served
+//	http_request_panics_total{method,route}      handlers that panicked
+//
+// Example
+//
+//	reg := cobalt.NewRegistry()
+//	c.Use(cobalt.Metrics(reg))
+//	c.Get("/metrics", reg.Handler())
+func Metrics(reg *Registry, buckets ...float64) MiddleWare {
+	requests := reg.Counter("http_requests_total", "Requests served by method, route and status class.", "method", "route", "status")
+	duration := reg.Histogram("http_request_duration_seconds", "Duration of requests in seconds.", buckets, "method", "route")
+	inFlight := reg.Gauge("http_requests_in_flight", "Requests being served.", "method", "route")
+	panics := reg.Counter("http_request_panics_total", "Requests whose handler panicked.", "method", "route")
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			method, route := ctx.Request.Method, ctx.Route()
+			st := time.Now()
+			inFlight.Add(1, method, route)
+
+			defer func() {
+				inFlight.Add(-1, method, route)
+
+				status := ctx.Writer().Status()
+				p := recover()
+				if p != nil {
+					panics.Inc(method, route)
+					status = http.StatusInternalServerError
+				}
+
+				requests.Inc(method, route, statusClass(status))
+				duration.Observe(time.Since(st).Seconds(), method, route)
+
+				if p != nil {
+					panic(p)
+				}
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// statusClass returns the class of status, such as "2xx". Responses without
+// a written status are sent as 200 by net/http.
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,120 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestRegistryExposition tests metrics are written in the text exposition
+// format.
+func TestRegistryExposition(t *testing.T) {
+	reg := cobalt.NewRegistry()
+
+	jobs := reg.Counter("jobs_total", "Jobs processed.\nBy queue.", "queue")
+	jobs.Inc("mail")
+	jobs.Add(2, `say "hi"`)
+
+	temp := reg.Gauge("temperature", "Current temperature.")
+	temp.Set(21.5)
+	temp.Add(-1)
+
+	size := reg.Histogram("size_bytes", "Sizes.", []float64{100, 10})
+	size.Observe(5)
+	size.Observe(50)
+	size.Observe(500)
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+
+	expected := `# HELP jobs_total Jobs processed.\nBy queue.
+# TYPE jobs_total counter
+jobs_total{queue="mail"} 1
+jobs_total{queue="say \"hi\""} 2
+# HELP size_bytes Sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="10"} 1
+size_bytes_bucket{le="100"} 2
+size_bytes_bucket{le="+Inf"} 3
+size_bytes_sum 555
+size_bytes_count 3
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 20.5
+`
+	if buf.String() != expected {
+		t.Errorf("expected exposition\n%s\ninstead got\n%s", expected, buf.String())
+	}
+}
+
+// TestRegistryPanics tests invalid use of the registry panics.
+func TestRegistryPanics(t *testing.T) {
+	tests := map[string]func(reg *cobalt.Registry){
+		"invalid name":      func(reg *cobalt.Registry) { reg.Counter("1bad", "") },
+		"duplicate":         func(reg *cobalt.Registry) { reg.Counter("dup", ""); reg.Gauge("dup", "") },
+		"label values":      func(reg *cobalt.Registry) { reg.Counter("c", "", "a").Inc() },
+		"counter decreases": func(reg *cobalt.Registry) { reg.Counter("c", "").Add(-1) },
+	}
+
+	for name, f := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			f(cobalt.NewRegistry())
+		}()
+	}
+}
+
+// TestMetricsMiddleware tests requests are recorded by route pattern and
+// status class and served on the metrics route.
+func TestMetricsMiddleware(t *testing.T) {
+	reg := cobalt.NewRegistry()
+
+	c := quiet(cobalt.Metrics(reg, 0.5, 1))
+	c.Get("/users/:id", func(ctx *cobalt.Context) {
+		if ctx.Request.URL.Path == "/users/0" {
+			ctx.ServeStatus(http.StatusNotFound)
+			return
+		}
+		ctx.ServeStatus(http.StatusOK)
+	})
+	c.Get("/panic", func(ctx *cobalt.Context) {
+		panic("boom")
+	})
+	c.Get("/metrics", reg.Handler())
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/0", "/panic"} {
+		get(c, path)
+	}
+
+	w := get(c, "/metrics")
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected exposition content type, got %s", ct)
+	}
+
+	out := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="/users/:id",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="/panic",status="5xx"} 1`,
+		`http_request_panics_total{method="GET",route="/panic"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/users/:id",le="0.5"} 3`,
+		`http_request_duration_seconds_count{method="GET",route="/users/:id"} 3`,
+		`http_requests_in_flight{method="GET",route="/users/:id"} 0`,
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected metrics to contain %s", line)
+		}
+	}
+	if strings.Contains(out, "/users/1") {
+		t.Error("expected raw paths not to be used as labels")
+	}
+}
@@ -0,0 +1,138 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// step returns middleware appending name to steps when it runs.
+func step(steps *[]string, name string) cobalt.MiddleWare {
+	return func(h cobalt.Handler) cobalt.Handler {
+		return func(ctx *cobalt.Context) {
+			*steps = append(*steps, name)
+			h(ctx)
+		}
+	}
+}
+
+// TestMiddlewareOrder tests middleware added with Use runs before the
+// middleware of routes, so it sees the requests they reject.
+func TestMiddlewareOrder(t *testing.T) {
+	var steps []string
+	var logged bytes.Buffer
+	c := quiet(cobalt.AccessLog(&logged, `%>s`), step(&steps, "use"))
+	c.Get("/limited", func(ctx *cobalt.Context) {
+		steps = append(steps, "handler")
+		ctx.ServeStatus(http.StatusOK)
+	}, cobalt.RateLimit(cobalt.NewTokenBucket(1, time.Minute, 1, nil), func(*cobalt.Context) string { return "all" }), step(&steps, "route"))
+
+	get(c, "/limited")
+	if w := get(c, "/limited"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second request to be limited, got %d", w.Code)
+	}
+
+	if expected := []string{"use", "route", "handler", "use", "route"}; !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected %v, got %v", expected, steps)
+	}
+	if lines := strings.Fields(logged.String()); !reflect.DeepEqual(lines, []string{"200", "429"}) {
+		t.Errorf("expected the rejected request to be logged, got %q", lines)
+	}
+}
+
+// TestGroupMiddlewareOrder tests the middleware of groups runs after the
+// middleware added with Use and before the middleware of their routes.
+func TestGroupMiddlewareOrder(t *testing.T) {
+	var steps []string
+	c := quiet(step(&steps, "use 2"), step(&steps, "use 1"))
+	api := c.Group("/api", step(&steps, "api"))
+	v1 := api.Group("/v1", step(&steps, "v1"))
+	v1.Use(step(&steps, "v1 use"))
+	v1.Get("/users", func(ctx *cobalt.Context) {
+		steps = append(steps, "handler")
+	}, step(&steps, "route 2"), step(&steps, "route 1"))
+
+	get(c, "/api/v1/users")
+	expected := []string{"use 1", "use 2", "api", "v1 use", "v1", "route 1", "route 2", "handler"}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected %v, got %v", expected, steps)
+	}
+}
+
+// TestTrackerWithTracing tests the tracker reports requests while middleware
+// replaces them, which the race detector checks.
+func TestTrackerWithTracing(t *testing.T) {
+	release := make(chan struct{})
+	c := quiet(cobalt.Tracing(cobalt.NewTracer(&cobalt.InMemoryExporter{})))
+	c.Get("/slow", func(ctx *cobalt.Context) {
+		<-release
+		ctx.ServeStatus(http.StatusOK)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(c, "/slow")
+		}()
+	}
+
+	var stats cobalt.TrackerStats
+	for i := 0; i < 100; i++ {
+		if stats = c.Tracker().Stats(); len(stats.Requests) == 4 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if len(stats.Requests) != 4 {
+		t.Fatalf("expected 4 requests in flight, got %d", len(stats.Requests))
+	}
+	for _, r := range stats.Requests {
+		if r.Method != "GET" || r.Path != "/slow" {
+			t.Errorf("expected GET /slow, got %+v", r)
+		}
+	}
+}
+
+// TestTimeoutSessions tests the session and writer used by handlers run by
+// Timeout are kept, whichever of the middleware runs first.
+func TestTimeoutSessions(t *testing.T) {
+	sessions := func() cobalt.MiddleWare {
+		return cobalt.Sessions(cobalt.SessionOptions{
+			Store: cobalt.NewMemorySessionStore(),
+			Keys:  [][]byte{[]byte("key-1")},
+		})
+	}
+	timeout := cobalt.Timeout(time.Second)
+
+	outer := quiet(timeout)
+	inner := quiet(sessions())
+	for i, g := range []*cobalt.Group{outer.Group("/users", sessions()), inner.Group("/users", timeout)} {
+		g.Get("/login", func(ctx *cobalt.Context) {
+			ctx.Session().Set("user", "ada")
+			ctx.Writer().WriteHeader(http.StatusAccepted)
+			ctx.Writer().Write([]byte("welcome"))
+		})
+		g.Get("/me", func(ctx *cobalt.Context) {
+			ctx.ServeResponse([]byte(ctx.Session().GetString("user")), http.StatusOK, "text/plain")
+		})
+
+		cl := newClient([]*cobalt.Cobalt{outer, inner}[i])
+		if w := cl.get("/users/login"); w.Code != http.StatusAccepted || w.Body.String() != "welcome" || cl.cookies["session"] == nil {
+			t.Fatalf("%d: expected the session cookie with the response, got %d %q %v", i, w.Code, w.Body.String(), w.Header())
+		}
+		if body := cl.get("/users/me").Body.String(); body != "ada" {
+			t.Errorf("%d: expected the session to be saved, got %q", i, body)
+		}
+	}
+}
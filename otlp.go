@@ -0,0 +1,349 @@
+package cobalt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the OTLPExporter.
+const (
+	otlpBatchSize = 512
+	otlpInterval  = 5 * time.Second
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding. Spans are sent in batches, when BatchSize spans are
+// waiting or every Interval. Spans that couldn't be sent are kept to be sent
+// again, up to MaxQueue. Shutdown must be called to send the remaining spans,
+// spans exported after it are dropped.
+type OTLPExporter struct {
+	// Endpoint is the URL spans are posted to, for example
+	// "http://localhost:4318/v1/traces".
+	Endpoint string
+
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+
+	// Client sends the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+
+	// BatchSize is the most spans sent in one request. It defaults to 512.
+	BatchSize int
+
+	// Interval is how often waiting spans are sent. It defaults to 5 seconds.
+	Interval time.Duration
+
+	// MaxQueue is the most spans waiting to be sent. The oldest spans are
+	// dropped when more are waiting, while the collector can't be reached.
+	// It defaults to 4 times BatchSize.
+	MaxQueue int
+
+	once    sync.Once
+	mu      sync.Mutex
+	spans   []SpanData
+	dropped int
+	stopped bool
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// start starts the goroutine sending batches the first time spans are
+// exported.
+func (e *OTLPExporter) start() {
+	e.once.Do(func() {
+		if e.BatchSize == 0 {
+			e.BatchSize = otlpBatchSize
+		}
+		if e.Interval == 0 {
+			e.Interval = otlpInterval
+		}
+		if e.MaxQueue == 0 {
+			e.MaxQueue = 4 * e.BatchSize
+		}
+		e.flush = make(chan struct{}, 1)
+		e.stop = make(chan struct{})
+		e.done = make(chan struct{})
+		go e.loop()
+	})
+}
+
+// ExportSpans implements Exporter. The spans are queued to be sent, or
+// dropped after Shutdown.
+func (e *OTLPExporter) ExportSpans(spans []SpanData) error {
+	e.start()
+
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return nil
+	}
+	e.spans = append(e.spans, spans...)
+	e.trim()
+	full := len(e.spans) >= e.BatchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of spans dropped because the queue was full or
+// they couldn't be sent on Shutdown.
+func (e *OTLPExporter) Dropped() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dropped
+}
+
+// trim drops the oldest spans over MaxQueue. e.mu must be held.
+func (e *OTLPExporter) trim() {
+	if n := len(e.spans) - e.MaxQueue; n > 0 {
+		e.spans = append([]SpanData(nil), e.spans[n:]...)
+		e.dropped += n
+	}
+}
+
+// loop sends the waiting spans every interval or when a batch is full. After
+// a failure, spans are only sent again on the next interval.
+func (e *OTLPExporter) loop() {
+	defer close(e.done)
+
+	t := time.NewTicker(e.Interval)
+	defer t.Stop()
+
+	var failed bool
+	for {
+		select {
+		case <-e.stop:
+			e.report(e.send(context.Background()))
+
+			e.mu.Lock()
+			e.dropped += len(e.spans)
+			e.spans = nil
+			e.mu.Unlock()
+			return
+		case <-t.C:
+		case <-e.flush:
+			if failed {
+				continue
+			}
+		}
+
+		err := e.send(context.Background())
+		failed = err != nil
+		e.report(err)
+	}
+}
+
+// report logs an error sending spans.
+func (e *OTLPExporter) report(err error) {
+	if err != nil {
+		defaultLogger.Error("could not export spans", Field{"endpoint", e.Endpoint}, Field{"error", err})
+	}
+}
+
+// send posts the waiting spans in batches. The spans not sent when a batch
+// fails are queued again, before the spans exported since.
+func (e *OTLPExporter) send(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > e.BatchSize {
+			n = e.BatchSize
+		}
+		if err := e.post(ctx, spans[:n]); err != nil {
+			e.mu.Lock()
+			e.spans = append(spans, e.spans...)
+			e.trim()
+			e.mu.Unlock()
+			return err
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+// Shutdown sends the waiting spans and stops the exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.start()
+
+	e.mu.Lock()
+	if !e.stopped {
+		e.stopped = true
+		close(e.stop)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// post sends one batch of spans to the collector.
+func (e *OTLPExporter) post(ctx context.Context, spans []SpanData) error {
+	b, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("cobalt: collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON request, see opentelemetry-proto's trace service.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		TraceState        string          `json:"traceState,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpStatusError  = 2
+)
+
+// request builds the OTLP request for spans.
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.TraceState,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Kind == SpanKindServer {
+			span.Kind = otlpKindServer
+		}
+		if s.Error {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.StatusMessage}
+		}
+		for k, v := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttr(k, v))
+		}
+		out[i] = span
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", e.ServiceName)}},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "cobalt"}, Spans: out}},
+		}},
+	}
+}
+
+// otlpAttr converts an attribute to its OTLP representation.
+func otlpAttr(key string, v interface{}) otlpAttribute {
+	var val otlpValue
+	switch v := v.(type) {
+	case string:
+		val.StringValue = &v
+	case bool:
+		val.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		val.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		val.IntValue = &s
+	case float64:
+		val.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		val.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: val}
+}
@@ -0,0 +1,262 @@
+package cobalt
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Forwarding headers.
+const (
+	forwardedHeader      = "Forwarded"
+	forwardedForHeader   = "X-Forwarded-For"
+	forwardedProtoHeader = "X-Forwarded-Proto"
+	forwardedHostHeader  = "X-Forwarded-Host"
+)
+
+// ProxyOptions configures which proxies cobalt trusts to report the client of
+// a request. The forwarding headers of requests from other addresses are
+// ignored, since clients can set them to anything.
+type ProxyOptions struct {
+	// TrustedProxies are the networks of the proxies and load balancers in
+	// front of the server. ParseCIDRs parses them from strings.
+	TrustedProxies []netip.Prefix
+}
+
+// ParseCIDRs parses networks in CIDR notation, such as "10.0.0.0/8". Single
+// addresses are accepted as networks of one address.
+func ParseCIDRs(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// trusted reports whether addr is a trusted proxy.
+func (o ProxyOptions) trusted(addr netip.Addr) bool {
+	for _, p := range o.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded is a hop of a request through proxies.
+type forwarded struct {
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+// client is the client of a request as resolved from the forwarding headers.
+type client struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+// resolve finds the client of req. The chain of hops reported by the
+// Forwarded header, or X-Forwarded-For when it is absent, is walked from the
+// closest hop back while the hops are trusted proxies. The first hop not
+// trusted is the client.
+func (o ProxyOptions) resolve(req *http.Request) client {
+	c := client{ip: remoteIP(req.RemoteAddr), scheme: "http", host: req.Host}
+	if req.TLS != nil {
+		c.scheme = "https"
+	}
+
+	remote, err := netip.ParseAddr(c.ip)
+	if err != nil || !o.trusted(remote.Unmap()) {
+		return c
+	}
+
+	hops, ok := forwardedHops(req.Header)
+	if !ok {
+		hops = xForwardedHops(req.Header)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if !h.addr.IsValid() {
+			// Hops can't be resolved past an obfuscated or invalid address.
+			break
+		}
+
+		c.ip = h.addr.String()
+		if h.proto != "" {
+			c.scheme = h.proto
+		}
+		if h.host != "" {
+			c.host = h.host
+		}
+
+		if !o.trusted(h.addr) {
+			break
+		}
+	}
+
+	return c
+}
+
+// remoteIP returns the IP address of a RemoteAddr.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// forwardedHops parses the Forwarded header of RFC 7239. It returns false if
+// there is no such header.
+func forwardedHops(h http.Header) ([]forwarded, bool) {
+	values := h.Values(forwardedHeader)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	var hops []forwarded
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			var hop forwarded
+			for _, pair := range splitQuoted(element, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(strings.TrimSpace(val), `"`)
+
+				switch strings.ToLower(strings.TrimSpace(k)) {
+				case "for":
+					hop.addr = parseNode(val)
+				case "proto":
+					hop.proto = strings.ToLower(val)
+				case "host":
+					hop.host = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops, true
+}
+
+// xForwardedHops parses the X-Forwarded-For header. The X-Forwarded-Proto
+// and X-Forwarded-Host headers apply to the closest hop, which was added by
+// the proxy in front of the server.
+func xForwardedHops(h http.Header) []forwarded {
+	var hops []forwarded
+	for _, v := range h.Values(forwardedForHeader) {
+		for _, s := range strings.Split(v, ",") {
+			hops = append(hops, forwarded{addr: parseNode(strings.TrimSpace(s))})
+		}
+	}
+
+	if len(hops) > 0 {
+		last := &hops[len(hops)-1]
+		last.proto = strings.ToLower(lastValue(h.Get(forwardedProtoHeader)))
+		last.host = lastValue(h.Get(forwardedHostHeader))
+	}
+	return hops
+}
+
+// lastValue returns the last element of a comma separated header value.
+func lastValue(v string) string {
+	if i := strings.LastIndex(v, ","); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
+
+// parseNode parses the address of a node, such as "192.0.2.1",
+// "192.0.2.1:80" or "[2001:db8::1]:80". It returns an invalid address for
+// obfuscated or unknown nodes.
+func parseNode(s string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap()
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return addr.Unmap()
+	}
+	return netip.Addr{}
+}
+
+// splitQuoted splits s on sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// client returns the client of the request, resolving it the first time.
+func (c *Context) client() client {
+	if c.resolved == nil {
+		cl := c.proxy.resolve(c.Request)
+		c.resolved = &cl
+	}
+	return *c.resolved
+}
+
+// ClientIP returns the IP address of the client. For requests from trusted
+// proxies it is the address they report in the forwarding headers, otherwise
+// it is the address of the connection.
+func (c *Context) ClientIP() string {
+	return c.client().ip
+}
+
+// Scheme returns the scheme the client used, "http" or "https", as reported
+// by trusted proxies or otherwise from the connection.
+func (c *Context) Scheme() string {
+	return c.client().scheme
+}
+
+// Host returns the host the client requested, as reported by trusted proxies
+// or otherwise from the request.
+func (c *Context) Host() string {
+	return c.client().host
+}
+
+// AbsoluteURL resolves ref, such as "/login", against the URL the client
+// requested, using the scheme and host reported by trusted proxies.
+func (c *Context) AbsoluteURL(ref string) string {
+	base := url.URL{Scheme: c.Scheme(), Host: c.Host(), Path: c.Request.URL.Path}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
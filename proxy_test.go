@@ -0,0 +1,108 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestClientIP tests the client is resolved through trusted proxies only.
+func TestClientIP(t *testing.T) {
+	trusted, err := cobalt.ParseCIDRs("10.0.0.0/8", "192.0.2.7", "2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, remote     string
+		header           http.Header
+		ip, scheme, host string
+	}{
+		{"direct", "203.0.113.9:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}}, "203.0.113.9", "http", "example.com"},
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1", "http", "example.com"},
+		{"x-forwarded-for", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.1.1.1"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}}, "198.51.100.1", "https", "api.example.com"},
+		{"spoofed", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1"}}, "198.51.100.1", "http", "example.com"},
+		{"multiple headers", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1", "192.0.2.7"}}, "198.51.100.1", "http", "example.com"},
+		{"all trusted", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}}, "10.2.2.2", "http", "example.com"},
+		{"invalid", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, garbage, 10.1.1.1"}}, "10.1.1.1", "http", "example.com"},
+		{"ipv6 remote", "[2001:db8::1]:443", http.Header{"X-Forwarded-For": {"2001:db9::5"}}, "2001:db9::5", "http", "example.com"},
+		{"mapped", "[::ffff:10.0.0.1]:80", http.Header{"X-Forwarded-For": {"::ffff:198.51.100.1"}}, "198.51.100.1", "http", "example.com"},
+		{"forwarded", "10.0.0.1:1234", http.Header{
+			"Forwarded":       {`for=198.51.100.1;proto=https;host=shop.example.com, for="[2001:db8::9]:8080";proto=http`},
+			"X-Forwarded-For": {"1.1.1.1"},
+		}, "198.51.100.1", "https", "shop.example.com"},
+		{"forwarded obfuscated", "10.0.0.1:1234", http.Header{"Forwarded": {`for=198.51.100.1, for=_hidden, for=10.1.1.1;proto=https`}}, "10.1.1.1", "https", "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip, scheme, host string
+			c := quiet()
+			c.Proxy.TrustedProxies = trusted
+			c.Get("/", func(ctx *cobalt.Context) {
+				ip, scheme, host = ctx.ClientIP(), ctx.Scheme(), ctx.Host()
+			})
+
+			r := NewRequest("GET", "http://example.com/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			do(c, r)
+
+			if ip != tt.ip || scheme != tt.scheme || host != tt.host {
+				t.Errorf("expected %s %s %s, got %s %s %s", tt.ip, tt.scheme, tt.host, ip, scheme, host)
+			}
+		})
+	}
+}
+
+// TestParseCIDRs tests networks and single addresses are parsed.
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := cobalt.ParseCIDRs("10.1.2.3/8", "192.0.2.7", "::1", "::ffff:172.16.0.0/108")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.7/32", "::1/128", "172.16.0.0/12"}
+	for i, p := range prefixes {
+		if p.String() != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], p)
+		}
+	}
+
+	if _, err := cobalt.ParseCIDRs("10.0.0.0/33"); err == nil {
+		t.Error("expected an invalid network to fail")
+	}
+}
+
+// TestProxyRedirect tests relative redirects stay relative and AbsoluteURL
+// uses the scheme and host reported by the proxy.
+func TestProxyRedirect(t *testing.T) {
+	c := quiet()
+	c.Proxy.TrustedProxies, _ = cobalt.ParseCIDRs("10.0.0.0/8")
+	c.Get("/account/settings", func(ctx *cobalt.Context) {
+		ctx.Redirect("../login", http.StatusFound)
+	})
+	c.Get("/account/public", func(ctx *cobalt.Context) {
+		ctx.Redirect(ctx.AbsoluteURL("../login"), http.StatusFound)
+	})
+
+	tests := []struct {
+		path, remote, location string
+	}{
+		{"/account/settings", "10.0.0.1:1234", "/login"},
+		{"/account/settings", "198.51.100.1:1234", "/login"},
+		{"/account/public", "10.0.0.1:1234", "https://www.example.com/login"},
+	}
+	for _, tt := range tests {
+		r := request("GET", "http://internal:8080"+tt.path, nil, "X-Forwarded-Proto", "https",
+			"X-Forwarded-Host", "www.example.com", "X-Forwarded-For", "198.51.100.1")
+		r.RemoteAddr = tt.remote
+		w := do(c, r)
+
+		if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != tt.location {
+			t.Errorf("%s from %s: expected a redirect to %s, got %d %s", tt.path, tt.remote, tt.location, w.Code, loc)
+		}
+	}
+}
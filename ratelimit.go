@@ -0,0 +1,339 @@
+package cobalt
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Rate limit headers, see the IETF RateLimit header fields draft.
+const (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+	retryAfterHeader         = "Retry-After"
+)
+
+type (
+	// RateLimiter decides whether the request identified by a key may
+	// proceed.
+	RateLimiter interface {
+		Allow(ctx context.Context, key string) (LimitResult, error)
+	}
+
+	// LimitResult is the outcome of a RateLimiter decision.
+	LimitResult struct {
+		// Allowed reports whether the request may proceed.
+		Allowed bool
+
+		// Limit is the number of requests allowed in a window or burst.
+		Limit int
+
+		// Remaining is the number of requests left.
+		Remaining int
+
+		// Reset is the time until the limit is fully restored.
+		Reset time.Duration
+
+		// RetryAfter is the time until a request is allowed again when it
+		// was not allowed.
+		RetryAfter time.Duration
+	}
+
+	// KeyFunc returns the key a request is limited by. Requests with an
+	// empty key are not limited.
+	KeyFunc func(ctx *Context) string
+
+	// RateLimitOptions controls how the RateLimit middleware responds when a
+	// request is limited.
+	RateLimitOptions struct {
+		// Body is encoded with the Coder and served with a 429 status. It
+		// defaults to a message saying the rate limit was exceeded.
+		Body interface{}
+
+		// OnLimited is called after the response to a limited request is
+		// served, for example to report it to metrics.
+		OnLimited func(ctx *Context, r LimitResult)
+	}
+
+	// tokenBucket is a RateLimiter refilling a bucket of tokens at a steady
+	// rate.
+	tokenBucket struct {
+		rate  float64
+		burst int
+		store RateLimitStore
+	}
+
+	// slidingWindow is a RateLimiter counting requests in a window sliding
+	// over the previous and current fixed windows.
+	slidingWindow struct {
+		limit  int
+		window time.Duration
+		store  RateLimitStore
+	}
+)
+
+// rateLimitBody is the default body served for limited requests.
+type rateLimitBody struct {
+	Error string
+}
+
+// RateLimit returns middleware limiting requests with l by the key returned
+// by key. Responses carry the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers and limited requests are answered with 429 Too Many
+// Requests and a Retry-After header. Requests are allowed when the limiter
+// fails, so an unavailable store doesn't take the service down; the error is
+// logged. You may also provide a single optional argument of type
+// RateLimitOptions to customize the response.
+//
+// Limiters sharing a store should be given keys that don't collide, for
+// example by using KeyByRoute.
+//
+// Example
+//
+//	limiter := cobalt.NewTokenBucket(10, time.Second, 20, nil)
+//	c.Use(cobalt.RateLimit(limiter, cobalt.KeyByIP))
+func RateLimit(l RateLimiter, key KeyFunc, options ...RateLimitOptions) MiddleWare {
+	var op RateLimitOptions
+	if len(options) > 0 {
+		op = options[0]
+	}
+	if op.Body == nil {
+		op.Body = rateLimitBody{Error: "rate limit exceeded"}
+	}
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			k := key(ctx)
+			if k == "" {
+				h(ctx)
+				return
+			}
+
+			r, err := l.Allow(ctx.Request.Context(), k)
+			if err != nil {
+				ctx.Logger().Error("rate limiter failed", Field{"error", err})
+				h(ctx)
+				return
+			}
+
+			header := ctx.Response.Header()
+			header.Set(rateLimitLimitHeader, strconv.Itoa(r.Limit))
+			header.Set(rateLimitRemainingHeader, strconv.Itoa(r.Remaining))
+			header.Set(rateLimitResetHeader, strconv.Itoa(seconds(r.Reset)))
+
+			if !r.Allowed {
+				header.Set(retryAfterHeader, strconv.Itoa(seconds(r.RetryAfter)))
+				ctx.ServeWithStatus(op.Body, http.StatusTooManyRequests)
+				if op.OnLimited != nil {
+					op.OnLimited(ctx, r)
+				}
+				return
+			}
+
+			h(ctx)
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// KeyByIP limits requests by the IP address of the client, as resolved
+// through the trusted proxies.
+func KeyByIP(ctx *Context) string {
+	return ctx.ClientIP()
+}
+
+// KeyByHeader returns a KeyFunc limiting requests by the value of a request
+// header, such as an API key. Requests without the header are not limited.
+func KeyByHeader(name string) KeyFunc {
+	return func(ctx *Context) string {
+		if v := ctx.Request.Header.Get(name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// KeyByData returns a KeyFunc limiting requests by the value stored under
+// key in the context data, such as the authenticated principal set by an
+// earlier middleware. Requests without the value are not limited.
+func KeyByData(key string) KeyFunc {
+	return func(ctx *Context) string {
+		switch v := ctx.GetData(key).(type) {
+		case nil:
+			return ""
+		case string:
+			if v == "" {
+				return ""
+			}
+			return key + ":" + v
+		case interface{ String() string }:
+			return key + ":" + v.String()
+		default:
+			return ""
+		}
+	}
+}
+
+// KeyByRoute returns a KeyFunc limiting requests by the route they match in
+// addition to the key returned by key, so each route has its own limit.
+func KeyByRoute(key KeyFunc) KeyFunc {
+	return func(ctx *Context) string {
+		k := key(ctx)
+		if k == "" {
+			return ""
+		}
+		return ctx.Request.Method + " " + ctx.Route() + "|" + k
+	}
+}
+
+// NewTokenBucket returns a RateLimiter allowing limit requests per period on
+// average and bursts of up to burst requests. The state is kept in store, or
+// in a new MemoryStore when store is nil. It panics if limit or per isn't
+// positive.
+func NewTokenBucket(limit int, per time.Duration, burst int, store RateLimitStore) RateLimiter {
+	if limit < 1 || per <= 0 {
+		panic("cobalt: token bucket requires a positive limit and period")
+	}
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: float64(limit) / per.Seconds(), burst: burst, store: store}
+}
+
+// Allow implements RateLimiter.
+func (b *tokenBucket) Allow(ctx context.Context, key string) (LimitResult, error) {
+	var r LimitResult
+	full := time.Duration(float64(b.burst) / b.rate * float64(time.Second))
+
+	err := b.store.Update(ctx, key, full, func(state []byte, now time.Time) []byte {
+		// The store may call the function again, as if it wasn't called.
+		r = LimitResult{Limit: b.burst}
+
+		// The state is the number of tokens and when it was computed.
+		tokens, last := float64(b.burst), now
+		if len(state) == 16 {
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+			last = time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+		}
+
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens = math.Min(float64(b.burst), tokens+elapsed.Seconds()*b.rate)
+		}
+
+		if tokens >= 1 {
+			tokens--
+			r.Allowed = true
+		} else {
+			r.RetryAfter = time.Duration((1 - tokens) / b.rate * float64(time.Second))
+		}
+		r.Remaining = int(tokens)
+		r.Reset = time.Duration((float64(b.burst) - tokens) / b.rate * float64(time.Second))
+
+		state = make([]byte, 16)
+		binary.BigEndian.PutUint64(state, math.Float64bits(tokens))
+		binary.BigEndian.PutUint64(state[8:], uint64(now.UnixNano()))
+		return state
+	})
+
+	return r, err
+}
+
+// NewSlidingWindow returns a RateLimiter allowing limit requests in any
+// window. The count is estimated from the counts of the current and previous
+// fixed windows, weighting the previous one by how much of it the sliding
+// window still covers. The state is kept in store, or in a new MemoryStore
+// when store is nil. It panics if limit or window isn't positive.
+func NewSlidingWindow(limit int, window time.Duration, store RateLimitStore) RateLimiter {
+	if limit < 1 || window <= 0 {
+		panic("cobalt: sliding window requires a positive limit and window")
+	}
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+	return &slidingWindow{limit: limit, window: window, store: store}
+}
+
+// Allow implements RateLimiter.
+func (s *slidingWindow) Allow(ctx context.Context, key string) (LimitResult, error) {
+	var r LimitResult
+	w := int64(s.window)
+
+	err := s.store.Update(ctx, key, 2*s.window, func(state []byte, now time.Time) []byte {
+		// The store may call the function again, as if it wasn't called.
+		r = LimitResult{Limit: s.limit}
+
+		t := now.UnixNano()
+		start := t - t%w
+
+		// The state is the start of the current window and the counts of the
+		// current and previous windows.
+		var curr, prev float64
+		if len(state) == 24 {
+			switch stored := int64(binary.BigEndian.Uint64(state)); stored {
+			case start:
+				curr = math.Float64frombits(binary.BigEndian.Uint64(state[8:]))
+				prev = math.Float64frombits(binary.BigEndian.Uint64(state[16:]))
+			case start - w:
+				prev = math.Float64frombits(binary.BigEndian.Uint64(state[8:]))
+			}
+		}
+
+		weight := 1 - float64(t-start)/float64(w)
+		count := prev*weight + curr
+
+		if count+1 <= float64(s.limit) {
+			curr++
+			count++
+			r.Allowed = true
+		} else {
+			r.RetryAfter = s.retryAfter(start, t, curr, prev)
+		}
+		r.Remaining = s.limit - int(math.Ceil(count))
+		if r.Remaining < 0 {
+			r.Remaining = 0
+		}
+		r.Reset = time.Duration(start + w - t)
+
+		state = make([]byte, 24)
+		binary.BigEndian.PutUint64(state, uint64(start))
+		binary.BigEndian.PutUint64(state[8:], math.Float64bits(curr))
+		binary.BigEndian.PutUint64(state[16:], math.Float64bits(prev))
+		return state
+	})
+
+	return r, err
+}
+
+// retryAfter returns the time from t until the estimated count leaves room
+// for another request.
+func (s *slidingWindow) retryAfter(start, t int64, curr, prev float64) time.Duration {
+	w := float64(s.window)
+	limit := float64(s.limit)
+
+	var at float64
+	if curr+1 <= limit {
+		// Within the current window, once enough of the previous one slid
+		// out. The request was denied, so the previous count is not zero.
+		at = float64(start) + w*(1-(limit-1-curr)/prev)
+	} else {
+		// In the next window, where the current count becomes the previous
+		// one.
+		at = float64(start) + w + w*(1-math.Max(limit-1, 0)/curr)
+	}
+
+	if d := time.Duration(at - float64(t)); d > 0 {
+		return d
+	}
+	return 0
+}
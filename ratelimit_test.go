@@ -0,0 +1,213 @@
+package cobalt_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// clockStore is a RateLimitStore with a clock moved by the test.
+type clockStore struct {
+	now    time.Time
+	states map[string][]byte
+}
+
+func (s *clockStore) Update(ctx context.Context, key string, ttl time.Duration, f func([]byte, time.Time) []byte) error {
+	s.states[key] = f(s.states[key], s.now)
+	return nil
+}
+
+// allow checks the decision of l for the key at the time of the store.
+func allow(t *testing.T, l cobalt.RateLimiter, expected bool) cobalt.LimitResult {
+	t.Helper()
+	r, err := l.Allow(context.Background(), "key")
+	if err != nil || r.Allowed != expected {
+		t.Errorf("expected allowed to be %t, got %+v %v", expected, r, err)
+	}
+	return r
+}
+
+// TestTokenBucket tests bursts are allowed and tokens refill at the rate.
+func TestTokenBucket(t *testing.T) {
+	store := clockStore{now: time.Unix(1000, 0), states: make(map[string][]byte)}
+	l := cobalt.NewTokenBucket(1, time.Second, 3, &store)
+
+	for i := 2; i >= 0; i-- {
+		if r := allow(t, l, true); r.Remaining != i || r.Limit != 3 {
+			t.Errorf("expected %d remaining of 3, got %+v", i, r)
+		}
+	}
+	if r := allow(t, l, false); r.RetryAfter != time.Second || r.Reset != 3*time.Second {
+		t.Errorf("expected to retry after a second, got %+v", r)
+	}
+
+	store.now = store.now.Add(1500 * time.Millisecond)
+	allow(t, l, true)
+	if r := allow(t, l, false); r.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected to retry after half a second, got %+v", r)
+	}
+}
+
+// TestSlidingWindow tests the previous window is weighted by its overlap
+// with the sliding window.
+func TestSlidingWindow(t *testing.T) {
+	store := clockStore{now: time.Unix(1000, 0), states: make(map[string][]byte)}
+	l := cobalt.NewSlidingWindow(4, 10*time.Second, &store)
+
+	for i := 0; i < 4; i++ {
+		allow(t, l, true)
+	}
+	if r := allow(t, l, false); r.RetryAfter != 12500*time.Millisecond || r.Remaining != 0 {
+		t.Errorf("expected to retry once a quarter of the next window passed, got %+v", r)
+	}
+
+	// Half of the previous window still counts, leaving room for two.
+	store.now = store.now.Add(15 * time.Second)
+	allow(t, l, true)
+	if r := allow(t, l, true); r.Remaining != 0 || r.Reset != 5*time.Second {
+		t.Errorf("expected no requests left until the window ends, got %+v", r)
+	}
+	if r := allow(t, l, false); r.RetryAfter != 2500*time.Millisecond {
+		t.Errorf("expected to retry once more of the previous window slid out, got %+v", r)
+	}
+
+	store.now = store.now.Add(2500 * time.Millisecond)
+	allow(t, l, true)
+
+	// Windows older than the previous one are forgotten.
+	store.now = store.now.Add(time.Minute)
+	for i := 0; i < 4; i++ {
+		allow(t, l, true)
+	}
+}
+
+// retryStore is a clockStore calling the function of updates once with no
+// state first, as a store retrying after a conflicting update would.
+type retryStore struct{ clockStore }
+
+func (s *retryStore) Update(ctx context.Context, key string, ttl time.Duration, f func([]byte, time.Time) []byte) error {
+	f(nil, s.now)
+	return s.clockStore.Update(ctx, key, ttl, f)
+}
+
+// TestRateLimitRetry tests the decision is the one of the last call of the
+// update function, and limiters can't be created without a limit.
+func TestRateLimitRetry(t *testing.T) {
+	limiters := map[string]func(cobalt.RateLimitStore) cobalt.RateLimiter{
+		"token bucket":   func(s cobalt.RateLimitStore) cobalt.RateLimiter { return cobalt.NewTokenBucket(1, time.Second, 1, s) },
+		"sliding window": func(s cobalt.RateLimitStore) cobalt.RateLimiter { return cobalt.NewSlidingWindow(1, time.Second, s) },
+	}
+	for name, limiter := range limiters {
+		store := retryStore{clockStore{now: time.Unix(1000, 0), states: make(map[string][]byte)}}
+		l := limiter(&store)
+		allow(t, l, true)
+		if r := allow(t, l, false); r.RetryAfter == 0 {
+			t.Errorf("%s: expected a retry delay, got %+v", name, r)
+		}
+	}
+
+	for name, create := range map[string]func(){
+		"token bucket":   func() { cobalt.NewTokenBucket(0, time.Second, 1, nil) },
+		"sliding window": func() { cobalt.NewSlidingWindow(0, time.Second, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic for a limit of 0", name)
+				}
+			}()
+			create()
+		}()
+	}
+}
+
+// errStore is a RateLimitStore that is unavailable.
+type errStore struct{}
+
+func (errStore) Update(context.Context, string, time.Duration, func([]byte, time.Time) []byte) error {
+	return errors.New("store unavailable")
+}
+
+// TestRateLimitMiddleware tests limited requests are answered with 429 and
+// the rate limit headers.
+func TestRateLimitMiddleware(t *testing.T) {
+	limited := 0
+
+	c := quiet()
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}, cobalt.RateLimit(cobalt.NewTokenBucket(1, time.Minute, 2, nil), cobalt.KeyByHeader("X-Api-Key"), cobalt.RateLimitOptions{
+		OnLimited: func(ctx *cobalt.Context, r cobalt.LimitResult) { limited++ },
+	}))
+	c.Get("/down", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	}, cobalt.RateLimit(cobalt.NewSlidingWindow(1, time.Minute, errStore{}), cobalt.KeyByIP))
+
+	as := func(path, key string) *httptest.ResponseRecorder {
+		r := request("GET", path, nil, "X-Api-Key", key)
+		r.RemoteAddr = "192.0.2.1:1234"
+		return do(c, r)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := as("/", "alice"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != fmt.Sprint(1-i) {
+			t.Errorf("expected request %d to be allowed, got %d %v", i, w.Code, w.Header())
+		}
+	}
+
+	w := as("/", "alice")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "rate limit exceeded") {
+		t.Errorf("expected 429 with an encoded body, got %d %s", w.Code, w.Body.String())
+	}
+	h := w.Header()
+	if h.Get("Retry-After") != "60" || h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Reset") != "120" {
+		t.Errorf("expected rate limit headers, got %v", h)
+	}
+	if limited != 1 {
+		t.Errorf("expected OnLimited to be called once, got %d", limited)
+	}
+
+	if w := as("/", "bob"); w.Code != http.StatusOK {
+		t.Errorf("expected other keys not to be limited, got %d", w.Code)
+	}
+	if w := as("/", ""); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected requests without a key not to be limited, got %d", w.Code)
+	}
+	if w := as("/down", ""); w.Code != http.StatusOK {
+		t.Errorf("expected requests to be allowed when the store fails, got %d", w.Code)
+	}
+}
+
+// TestMemoryStoreEviction tests the store doesn't grow past its size.
+func TestMemoryStoreEviction(t *testing.T) {
+	store := cobalt.NewMemoryStore(128)
+	l := cobalt.NewTokenBucket(1, time.Second, 1, store)
+
+	for i := 0; i < 10000; i++ {
+		l.Allow(context.Background(), fmt.Sprint("client-", i))
+	}
+	if n := store.Len(); n > 128 || n < 64 {
+		t.Errorf("expected about 128 keys, got %d", n)
+	}
+
+	// Expired keys are evicted as the store is used.
+	store = cobalt.NewMemoryStore(0)
+	l = cobalt.NewTokenBucket(1000, time.Millisecond, 1, store)
+	for i := 0; i < 5000; i++ {
+		l.Allow(context.Background(), fmt.Sprint("client-", i))
+	}
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 2*64*1024; i++ {
+		l.Allow(context.Background(), fmt.Sprint("busy-", i%512))
+	}
+	if n := store.Len(); n > 512 {
+		t.Errorf("expected expired keys to be evicted, got %d", n)
+	}
+}
@@ -0,0 +1,92 @@
+package cobalt
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+type (
+	// RecoveryOptions controls what cobalt does when a handler panics. With
+	// the zero value the panic is logged with its stack and the ServerErr
+	// handler, or a plain 500, is served.
+	RecoveryOptions struct {
+		// Reporter is called with every recovered panic, for example to send
+		// it to an error tracking service. It is called on the request
+		// goroutine before the response is written.
+		Reporter func(PanicReport)
+
+		// Repanic panics again with the recovered value once the panic is
+		// logged and reported, leaving it to net/http, which logs it and
+		// closes the connection. It is meant for development, so panics are
+		// not missed.
+		Repanic bool
+	}
+
+	// PanicReport describes a panic recovered while serving a request.
+	PanicReport struct {
+		// Value is the value passed to panic.
+		Value interface{}
+
+		// Stack is the full stack trace of the goroutine that panicked.
+		Stack []byte
+
+		// Request is the request being served.
+		Request *http.Request
+
+		// ID is the ID of the request.
+		ID string
+	}
+)
+
+// recovered handles the panic p of the handler serving ctx. Handlers that
+// panic with http.ErrAbortHandler mean to abort the response, so the panic is
+// passed on to net/http silently. When the headers have already been sent
+// the error can't be reported to the client, so the response is aborted too
+// instead of leaving a truncated response looking complete.
+func (c *Cobalt) recovered(ctx *Context, p interface{}) {
+	// Panics of handlers run on another goroutine carry their stack.
+	var stack []byte
+	if hp, ok := p.(handlerPanic); ok {
+		p, stack = hp.value, hp.stack
+	}
+
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+
+	if stack == nil {
+		stack = debug.Stack()
+	}
+	ctx.Logger().Error("panic recovered", Field{"panic", p}, Field{"stack", string(stack)})
+
+	if c.Recovery.Reporter != nil {
+		c.report(ctx, PanicReport{Value: p, Stack: stack, Request: ctx.Request, ID: ctx.ID})
+	}
+
+	if c.Recovery.Repanic {
+		ctx.Status = http.StatusInternalServerError
+		panic(p)
+	}
+
+	if ctx.writer.Written() {
+		panic(http.ErrAbortHandler)
+	}
+
+	if c.serverError != nil {
+		c.serverError(ctx)
+		return
+	}
+	ctx.writer.WriteHeader(http.StatusInternalServerError)
+}
+
+// report calls the Reporter, logging a panic of the Reporter itself so the
+// response can still be written.
+func (c *Cobalt) report(ctx *Context, r PanicReport) {
+	defer func() {
+		if p := recover(); p != nil {
+			ctx.Logger().Error("panic reporter failed", Field{"panic", p})
+		}
+	}()
+
+	c.Recovery.Reporter(r)
+}
@@ -0,0 +1,123 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestRecoveryReporter tests panics are reported with the full stack and the
+// ServerErr handler is served.
+func TestRecoveryReporter(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	var report cobalt.PanicReport
+	var id string
+
+	c := quiet()
+	c.Recovery.Reporter = func(r cobalt.PanicReport) {
+		report = r
+	}
+	c.ServerErr(func(ctx *cobalt.Context) {
+		ctx.ServeWithStatus(map[string]string{"error": "internal"}, http.StatusInternalServerError)
+	})
+	c.Get("/", func(ctx *cobalt.Context) {
+		id = ctx.ID
+		deepPanic(20)
+	})
+
+	c.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "internal") {
+		t.Errorf("expected ServerErr response, got %d %s", w.Code, w.Body.String())
+	}
+	if report.Value != "deep" || report.ID != id || report.Request == nil || report.Request.URL.Path != "/" {
+		t.Errorf("expected report of the panic, got %+v", report)
+	}
+
+	// The stack is longer than the old fixed buffer and is not cut off.
+	if bytes.Count(report.Stack, []byte("deepPanic")) != 21 {
+		t.Errorf("expected the full stack, got %d frames", bytes.Count(report.Stack, []byte("deepPanic")))
+	}
+}
+
+// deepPanic panics n calls deep.
+func deepPanic(n int) {
+	if n == 0 {
+		panic("deep")
+	}
+	deepPanic(n - 1)
+}
+
+// TestRecoveryRepanic tests the panic is passed on in development.
+func TestRecoveryRepanic(t *testing.T) {
+	reported := false
+
+	c := quiet()
+	c.Recovery = cobalt.RecoveryOptions{
+		Reporter: func(cobalt.PanicReport) { reported = true },
+		Repanic:  true,
+	}
+	c.Get("/", func(ctx *cobalt.Context) {
+		panic("Panic Test")
+	})
+
+	defer func() {
+		if p := recover(); p != "Panic Test" {
+			t.Errorf("expected the panic to be passed on, got %v", p)
+		}
+		if !reported {
+			t.Error("expected the panic to be reported first")
+		}
+	}()
+
+	get(c, "/")
+}
+
+// TestRecoveryAbort tests responses already sent are aborted and that
+// http.ErrAbortHandler is not reported.
+func TestRecoveryAbort(t *testing.T) {
+	var reports int32
+
+	c := quiet()
+	c.Recovery.Reporter = func(cobalt.PanicReport) {
+		atomic.AddInt32(&reports, 1)
+	}
+	c.Get("/written", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte("partial"))
+		ctx.Response.(http.Flusher).Flush()
+		panic("Panic Test")
+	})
+	c.Get("/abort", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte("partial"))
+		ctx.Response.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+
+	s := httptest.NewServer(c)
+	defer s.Close()
+
+	for _, path := range []string{"/written", "/abort"} {
+		resp, err := plain.Get(s.URL + path)
+		if err != nil {
+			t.Fatalf("%s: expected headers, got %v", path, err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != "partial" || err == nil {
+			t.Errorf("%s: expected truncated response, got %d %q %v", path, resp.StatusCode, b, err)
+		}
+	}
+
+	if reports := atomic.LoadInt32(&reports); reports != 1 {
+		t.Errorf("expected only the handler panic to be reported, got %d", reports)
+	}
+}
@@ -0,0 +1,184 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// defaultMaxRequestID is the longest inbound request ID accepted by default.
+const defaultMaxRequestID = 128
+
+// RequestIDOptions controls how cobalt assigns an ID to every request. The
+// zero value accepts valid inbound IDs from the X-Request-Id header and
+// generates a random UUID otherwise.
+type RequestIDOptions struct {
+	// Header is the request and response header that carries the ID. It
+	// defaults to X-Request-Id.
+	Header string
+
+	// Generator creates the ID for requests without a usable inbound ID. It
+	// defaults to a random UUID. NewUUIDv7 and NewULID generate IDs that
+	// sort by time.
+	Generator func() string
+
+	// IgnoreInbound always generates a new ID instead of trusting the one
+	// sent by the client.
+	IgnoreInbound bool
+
+	// MaxLength is the longest inbound ID accepted. It defaults to 128.
+	MaxLength int
+
+	// Validate reports whether an inbound ID may be used. It defaults to
+	// accepting letters, digits and the characters -_.:+/= so IDs can't be
+	// used to inject content into logs.
+	Validate func(id string) bool
+}
+
+// header returns the header that carries the ID.
+func (o RequestIDOptions) header() string {
+	if o.Header != "" {
+		return o.Header
+	}
+	return idHeader
+}
+
+// requestID returns the ID for req.
+func (o RequestIDOptions) requestID(req *http.Request) string {
+	if !o.IgnoreInbound {
+		if id := req.Header.Get(o.header()); id != "" && o.valid(id) {
+			return id
+		}
+	}
+
+	if o.Generator != nil {
+		return o.Generator()
+	}
+	return uuid.New()
+}
+
+// valid reports whether the inbound id may be used.
+func (o RequestIDOptions) valid(id string) bool {
+	max := o.MaxLength
+	if max == 0 {
+		max = defaultMaxRequestID
+	}
+	if len(id) > max {
+		return false
+	}
+
+	if o.Validate != nil {
+		return o.Validate(id)
+	}
+
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		case b == '-', b == '_', b == '.', b == ':', b == '+', b == '/', b == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDKey is the key for the request ID in a context.Context.
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID stored in the context of a
+// request served by cobalt, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDTransport is an http.RoundTripper that propagates the request ID
+// to downstream services. It sets the ID stored in the context of outgoing
+// requests, see RequestIDFromContext, on the Header.
+//
+// Example
+//
+//	client := http.Client{Transport: cobalt.RequestIDTransport{}}
+//	req, _ := http.NewRequestWithContext(ctx.Request.Context(), "GET", url, nil)
+//	client.Do(req)
+type RequestIDTransport struct {
+	// Base makes the requests. It defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Header carries the ID. It defaults to X-Request-Id.
+	Header string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	header := t.Header
+	if header == "" {
+		header = idHeader
+	}
+
+	if id := RequestIDFromContext(req.Context()); id != "" && req.Header.Get(header) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(header, id)
+	}
+	return base.RoundTrip(req)
+}
+
+// NewUUIDv7 returns a version 7 UUID. They start with the time in
+// milliseconds, so they sort in the order they were created.
+func NewUUIDv7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// crockford is the base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: 26 characters holding the time in milliseconds
+// followed by 80 random bits, so they sort in the order they were created.
+func NewULID() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+
+	// 128 bits are encoded 5 bits at a time from the most significant end,
+	// the first character holding only the top 3 bits.
+	hi := binary.BigEndian.Uint64(u[0:])
+	lo := binary.BigEndian.Uint64(u[8:])
+
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
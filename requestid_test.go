@@ -0,0 +1,130 @@
+package cobalt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// requestID serves a request with the inbound id through c and returns the
+// ID seen by the handler.
+func requestID(t *testing.T, c *cobalt.Cobalt, header, inbound string) string {
+	r := NewRequest("GET", "/", nil)
+	if inbound != "" {
+		r.Header.Set(header, inbound)
+	}
+	w := httptest.NewRecorder()
+
+	var id string
+	c.DisableRequestLog = true
+	c.Get("/", func(ctx *cobalt.Context) {
+		id = ctx.ID
+		if got := cobalt.RequestIDFromContext(ctx.Request.Context()); got != id {
+			t.Errorf("expected request context to hold %s, got %s", id, got)
+		}
+	})
+
+	c.ServeHTTP(w, r)
+
+	if got := w.Header().Get(header); got != id {
+		t.Errorf("expected response header %s to be %s, got %s", header, id, got)
+	}
+	return id
+}
+
+// TestRequestIDInbound tests which inbound IDs are trusted.
+func TestRequestIDInbound(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		keep    bool
+	}{
+		{"valid", "abc-123_DEF.4", true},
+		{"injection", "abc\n[cobalt] fake entry", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		id := requestID(t, cobalt.New(&JSONEncoder{}), "X-Request-Id", tt.inbound)
+		if (id == tt.inbound) != tt.keep {
+			t.Errorf("%s: expected inbound ID to be kept %t, got %q", tt.name, tt.keep, id)
+		}
+		if id == "" {
+			t.Errorf("%s: expected an ID", tt.name)
+		}
+	}
+}
+
+// TestRequestIDOptions tests a custom header and generator and ignoring
+// inbound IDs.
+func TestRequestIDOptions(t *testing.T) {
+	c := cobalt.New(&JSONEncoder{})
+	c.RequestID = cobalt.RequestIDOptions{
+		Header:        "X-Trace",
+		Generator:     func() string { return "generated" },
+		IgnoreInbound: true,
+	}
+
+	if id := requestID(t, c, "X-Trace", "inbound"); id != "generated" {
+		t.Errorf("expected generated ID, got %s", id)
+	}
+}
+
+// TestRequestIDGenerators tests the sortable ID generators.
+func TestRequestIDGenerators(t *testing.T) {
+	v7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	first, firstULID := cobalt.NewUUIDv7(), cobalt.NewULID()
+	time.Sleep(2 * time.Millisecond)
+	second, secondULID := cobalt.NewUUIDv7(), cobalt.NewULID()
+
+	if !v7.MatchString(first) {
+		t.Errorf("expected a version 7 UUID, got %s", first)
+	}
+	if !ulid.MatchString(firstULID) {
+		t.Errorf("expected a ULID, got %s", firstULID)
+	}
+	if first >= second || firstULID >= secondULID {
+		t.Error("expected IDs to sort by time")
+	}
+}
+
+// TestRequestIDTransport tests the ID is propagated to downstream requests.
+func TestRequestIDTransport(t *testing.T) {
+	var got string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-Id")
+	}))
+	defer downstream.Close()
+
+	client := http.Client{Transport: cobalt.RequestIDTransport{}}
+
+	c := cobalt.New(&JSONEncoder{})
+	id := requestID(t, c, "X-Request-Id", "upstream-id")
+	if id != "upstream-id" {
+		t.Fatalf("expected inbound ID, got %s", id)
+	}
+
+	c.Get("/call", func(ctx *cobalt.Context) {
+		req, _ := http.NewRequestWithContext(ctx.Request.Context(), "GET", downstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("expected no error calling downstream, got %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	r := NewRequest("GET", "/call", nil)
+	r.Header.Set("X-Request-Id", "upstream-id")
+	do(c, r)
+
+	if got != "upstream-id" {
+		t.Errorf("expected downstream to receive upstream-id, got %s", got)
+	}
+}
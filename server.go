@@ -0,0 +1,358 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long Run waits for in flight requests to finish
+// once a shutdown signal is received.
+const shutdownTimeout = 10 * time.Second
+
+// Server configures an http server run by cobalt. Additional servers are
+// registered with AddServer and share the lifecycle of the main server
+// started by Run or RunTLS: they start together and are shut down together
+// when the process receives an Interrupt or SIGTERM signal. On SIGHUP the
+// certificates of every server are reloaded.
+type Server struct {
+	// Addr is the TCP address to listen on. Use a loopback address such as
+	// "127.0.0.1:9090" to keep a server off the public network.
+	Addr string
+
+	// Listener, if set, is used instead of listening on Addr.
+	Listener net.Listener
+
+	// Handler serves the requests for this server. It is usually another
+	// *Cobalt with its own routes. It defaults to the Cobalt that runs it.
+	Handler http.Handler
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// CertFile and KeyFile enable TLS for this server when set. The files
+	// are watched and the certificate reloaded when they change.
+	CertFile string
+	KeyFile  string
+
+	// Certs, if set, serves the certificate for this server instead of
+	// CertFile and KeyFile.
+	Certs *CertManager
+
+	// ClientAuth is the policy for TLS client authentication. Client
+	// certificates are verified against ClientCAs, see LoadCertPool. The
+	// verified identity is available from Context.ClientIdentity. It
+	// requires the server to be served over TLS.
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+
+	// TLSConfig optionally configures TLS for this server. TLS is also
+	// enabled when it holds certificates on its own.
+	TLSConfig *tls.Config
+
+	// H2C enables HTTP/2 over cleartext connections (h2c) for clients with
+	// prior knowledge, such as services behind a mesh. HTTP/1.1 is still
+	// served, requests asking to switch with "Upgrade: h2c" are answered
+	// over HTTP/1.1.
+	H2C bool
+
+	// HTTP2 configures HTTP/2 settings such as MaxConcurrentStreams.
+	HTTP2 *http.HTTP2Config
+}
+
+// AddServer registers an additional server to be run alongside the main
+// server by Run and RunTLS.
+//
+// Example
+//
+//	admin := cobalt.New(coder)
+//	admin.Get("/health", health)
+//	app.AddServer(cobalt.Server{Addr: "127.0.0.1:9090", Handler: admin})
+//	app.Run(":8080", 10*time.Second, 10*time.Second)
+func (c *Cobalt) AddServer(s Server) {
+	c.servers = append(c.servers, s)
+}
+
+// RunServer runs the dispatcher with s as the main server. It blocks waiting
+// for a signal and performs graceful shutdown just like Run. Use it when the
+// main server needs more configuration than Run and RunTLS provide.
+//
+// Example
+//
+//	c.RunServer(cobalt.Server{Addr: ":8080", H2C: true})
+func (c *Cobalt) RunServer(s Server) {
+	c.run(s)
+}
+
+// Run runs the dispatcher which starts an http server to listen and serve.
+// This operation blocks until an Inerrupt or SIGTERM signal is received at
+// which point it starts a 10 second graceful shutdown. If requests do not
+// finish in that time the whole application exits. Servers registered with
+// AddServer are run and shut down together with the main server.
+func (c *Cobalt) Run(addr string, readtimeout, writetimeout time.Duration) {
+	c.run(Server{Addr: addr, ReadTimeout: readtimeout, WriteTimeout: writetimeout})
+}
+
+// RunTLS runs the dispatcher with a TLS cert. It blocks waiting for a signal
+// and performs graceful shutdown just like Run.
+func (c *Cobalt) RunTLS(addr, certfile, keyfile string, readtimeout, writetimeout time.Duration) {
+	c.run(Server{Addr: addr, CertFile: certfile, KeyFile: keyfile, ReadTimeout: readtimeout, WriteTimeout: writetimeout})
+}
+
+func (c *Cobalt) run(main Server) {
+	l := c.logger()
+	servers := append([]Server{main}, c.servers...)
+
+	// Load the certificates of the TLS servers and keep them up to date
+	// until we return.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for i := range servers {
+		if err := servers[i].loadCerts(l); err != nil {
+			l.Error("could not load certificates", Field{"addr", servers[i].addr()}, Field{"error", err})
+			os.Exit(1)
+		}
+		if servers[i].Certs != nil && servers[i].CertFile != "" {
+			go servers[i].Certs.Watch(certWatchInterval, stop)
+		}
+	}
+
+	// Make a channel to listen for an interrupt, terminate or hangup signal
+	// from the OS. Use a buffered channel because the signal package requires it.
+	osSignals := make(chan os.Signal, 1)
+	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(osSignals)
+
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel so the goroutines can exit if we don't collect the errors.
+	serverErrors := make(chan error, len(servers))
+
+	// Start the services listening for requests.
+	srvs := make([]*http.Server, len(servers))
+	trackers := make([]*Tracker, len(servers))
+	for i := range servers {
+		s := servers[i]
+		srv := s.httpServer(c)
+		srvs[i] = srv
+		trackers[i] = s.tracker(c)
+		srv.ConnState = trackers[i].connState
+
+		l.Info("starting", Field{"addr", s.addr()})
+		go func() {
+			serverErrors <- s.serve(srv)
+		}()
+	}
+
+	// Blocking waiting for shutdown or an error
+	for {
+		select {
+		case err := <-serverErrors:
+			l.Error("could not start server", Field{"error", err})
+			os.Exit(1)
+
+		case sig := <-osSignals:
+			if sig == syscall.SIGHUP {
+				reloadCerts(l, servers)
+				continue
+			}
+
+			shutdown(l, srvs, trackers)
+			return
+		}
+	}
+}
+
+// reloadCerts reloads the certificates of all TLS servers.
+func reloadCerts(l Logger, servers []Server) {
+	for _, s := range servers {
+		if s.Certs == nil {
+			continue
+		}
+		if err := s.Certs.Reload(); err != nil {
+			l.Error("could not reload certificate", Field{"addr", s.addr()}, Field{"error", err})
+			continue
+		}
+		l.Info("reloaded certificate", Field{"addr", s.addr()})
+	}
+}
+
+// shutdown gracefully shuts down all servers, sharing the one timeout. The
+// requests still running when the timeout expires are logged before the
+// servers are closed.
+func shutdown(l Logger, srvs []*http.Server, trackers []*Tracker) {
+
+	// Create context for Shutdown calls.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Ask every listener to shutdown and load shed at the same time.
+	failed := make([]bool, len(srvs))
+	var wg sync.WaitGroup
+	for i := range srvs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := srvs[i].Shutdown(ctx); err != nil {
+				l.Error("graceful shutdown did not complete", Field{"addr", srvs[i].Addr}, Field{"timeout", shutdownTimeout}, Field{"error", err})
+				failed[i] = true
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Report what was still running, once per tracker as servers may share
+	// one, then force the servers closed.
+	logged := make(map[*Tracker]bool)
+	for i := range srvs {
+		if !failed[i] {
+			continue
+		}
+
+		if t := trackers[i]; !logged[t] {
+			logged[t] = true
+			for _, r := range t.Stats().Requests {
+				l.Error("request still running",
+					Field{"request_id", r.ID},
+					Field{"method", r.Method},
+					Field{"path", r.Path},
+					Field{"age", r.Age},
+					Field{"remote_addr", r.RemoteAddr},
+				)
+			}
+		}
+
+		if err := srvs[i].Close(); err != nil {
+			l.Error("could not stop server", Field{"addr", srvs[i].Addr}, Field{"error", err})
+			os.Exit(1)
+		}
+	}
+}
+
+// httpServer builds the http.Server described by s. Requests are served by c
+// unless s has a Handler of its own.
+func (s Server) httpServer(c *Cobalt) *http.Server {
+	h := s.Handler
+	if h == nil {
+		h = c
+	}
+
+	srv := http.Server{
+		Addr:         s.addr(),
+		Handler:      h,
+		ReadTimeout:  s.ReadTimeout,
+		WriteTimeout: s.WriteTimeout,
+		IdleTimeout:  s.IdleTimeout,
+		TLSConfig:    s.tlsConfig(),
+		HTTP2:        s.HTTP2,
+	}
+
+	if s.H2C {
+		var p http.Protocols
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+		srv.Protocols = &p
+	}
+
+	return &srv
+}
+
+// tracker returns the Tracker for the requests served by s. Servers with a
+// handler other than a *Cobalt are tracked by c.
+func (s Server) tracker(c *Cobalt) *Tracker {
+	if h, ok := s.Handler.(*Cobalt); ok {
+		return h.tracker
+	}
+	return c.tracker
+}
+
+// loadCerts creates the CertManager for a server configured with a CertFile
+// and KeyFile, logging its reloads to l.
+func (s *Server) loadCerts(l Logger) error {
+	if s.ClientAuth != tls.NoClientCert && !s.tls() {
+		return errClientAuthNoTLS
+	}
+	if s.ClientAuth >= tls.VerifyClientCertIfGiven && s.ClientCAs == nil {
+		return errNoClientCAs
+	}
+	if s.Certs != nil || (s.CertFile == "" && s.KeyFile == "") {
+		return nil
+	}
+
+	m, err := NewCertManager(s.CertFile, s.KeyFile, CertOptions{Logger: l})
+	if err != nil {
+		return err
+	}
+	s.Certs = m
+	return nil
+}
+
+// tlsConfig returns the TLS configuration for the server.
+func (s Server) tlsConfig() *tls.Config {
+	if !s.tls() {
+		return s.TLSConfig
+	}
+
+	cfg := &tls.Config{}
+	if s.TLSConfig != nil {
+		cfg = s.TLSConfig.Clone()
+	}
+	if s.Certs != nil {
+		cfg.GetCertificate = s.Certs.GetCertificate
+	}
+	if s.ClientAuth != tls.NoClientCert {
+		cfg.ClientAuth = s.ClientAuth
+		cfg.ClientCAs = s.ClientCAs
+	}
+	return cfg
+}
+
+// addr returns the address the server listens on.
+func (s Server) addr() string {
+	if s.Listener != nil {
+		return s.Listener.Addr().String()
+	}
+	if s.Addr != "" {
+		return s.Addr
+	}
+	if s.tls() {
+		return ":https"
+	}
+	return ":http"
+}
+
+// tls reports whether the server should be served over TLS.
+func (s Server) tls() bool {
+	if s.CertFile != "" || s.KeyFile != "" || s.Certs != nil {
+		return true
+	}
+	return s.TLSConfig != nil && (len(s.TLSConfig.Certificates) > 0 || s.TLSConfig.GetCertificate != nil)
+}
+
+// serve accepts connections for srv until it is shut down.
+func (s Server) serve(srv *http.Server) error {
+	ln := s.Listener
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", srv.Addr); err != nil {
+			return err
+		}
+	}
+
+	if s.tls() {
+		if s.Certs != nil {
+			return srv.ServeTLS(ln, "", "")
+		}
+		return srv.ServeTLS(ln, s.CertFile, s.KeyFile)
+	}
+	return srv.Serve(ln)
+}
@@ -0,0 +1,159 @@
+package cobalt_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// plain is the client used to talk to servers started by Run. It does not
+// keep connections alive so none are left open, keeping shutdown quick.
+var plain = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// listen opens a listener on a random loopback port.
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error listening, got %v", err)
+	}
+	return ln
+}
+
+// waitFor polls url until it answers or the test times out.
+func waitFor(t *testing.T, client *http.Client, url string) *http.Response {
+	for i := 0; i < 100; i++ {
+		resp, err := client.Get(url)
+		if err == nil {
+			return resp
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("server at %s never came up", url)
+	return nil
+}
+
+// stop sends SIGTERM to the test process, which Run catches, and waits for
+// done to be closed.
+func stop(t *testing.T, done chan struct{}) {
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("expected no error signaling, got %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return after SIGTERM")
+	}
+}
+
+// body reads and closes the body of resp.
+func body(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected no error reading body, got %v", err)
+	}
+	return string(b)
+}
+
+// TestRunMultipleServers tests running a public and an admin server with
+// their own routers under one Run call.
+func TestRunMultipleServers(t *testing.T) {
+	app := cobalt.New(&JSONEncoder{})
+	app.Get("/", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte("public"))
+	})
+
+	admin := cobalt.New(&JSONEncoder{})
+	admin.Get("/health", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte("ok"))
+	})
+
+	pub := listen(t)
+	adm := listen(t)
+	app.AddServer(cobalt.Server{Listener: pub})
+	app.AddServer(cobalt.Server{Listener: adm, Handler: admin, ReadTimeout: time.Second})
+
+	done := make(chan struct{})
+	go func() {
+		app.Run("127.0.0.1:0", time.Second, time.Second)
+		close(done)
+	}()
+
+	if got := body(t, waitFor(t, plain, "http://"+pub.Addr().String()+"/")); got != "public" {
+		t.Errorf("expected body to be public instead got %s", got)
+	}
+	if got := body(t, waitFor(t, plain, "http://"+adm.Addr().String()+"/health")); got != "ok" {
+		t.Errorf("expected body to be ok instead got %s", got)
+	}
+
+	resp := waitFor(t, plain, "http://"+adm.Addr().String()+"/")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected admin server to not serve public routes, got %d", resp.StatusCode)
+	}
+
+	stop(t, done)
+
+	if _, err := plain.Get("http://" + adm.Addr().String() + "/health"); err == nil {
+		t.Error("expected admin server to be shut down")
+	}
+}
+
+// TestRunH2C tests serving HTTP/2 over cleartext to clients with prior
+// knowledge while still serving HTTP/1.1.
+func TestRunH2C(t *testing.T) {
+	c := cobalt.New(&JSONEncoder{})
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.Response.Write([]byte(ctx.Protocol()))
+	})
+
+	ln := listen(t)
+	c.AddServer(cobalt.Server{
+		Listener: ln,
+		H2C:      true,
+		HTTP2:    &http.HTTP2Config{MaxConcurrentStreams: 10},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		c.Run("127.0.0.1:0", time.Second, time.Second)
+		close(done)
+	}()
+
+	url := "http://" + ln.Addr().String() + "/"
+	if got := body(t, waitFor(t, plain, url)); got != "http/1.1" {
+		t.Errorf("expected body to be http/1.1 instead got %s", got)
+	}
+
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	h2c := &http.Client{Transport: &http.Transport{Protocols: &p, DisableKeepAlives: true}}
+
+	resp := waitFor(t, h2c, url)
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 response instead got %s", resp.Proto)
+	}
+	if got := body(t, resp); got != "h2c" {
+		t.Errorf("expected body to be h2c instead got %s", got)
+	}
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAAQAAP__")
+	resp, err := plain.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := body(t, resp); resp.StatusCode != http.StatusOK || got != "http/1.1" {
+		t.Errorf("expected the request asking to switch to be served over http/1.1 instead got %d %s", resp.StatusCode, got)
+	}
+
+	stop(t, done)
+}
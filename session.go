@@ -0,0 +1,449 @@
+package cobalt
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults for sessions.
+const (
+	defaultSessionCookie   = "session"
+	defaultIdleTimeout     = 30 * time.Minute
+	defaultAbsoluteTimeout = 12 * time.Hour
+)
+
+type (
+	// SessionStore keeps the data of sessions.
+	SessionStore interface {
+		// Load returns the data of the session id, or nil if there is none
+		// or it expired.
+		Load(ctx context.Context, id string) ([]byte, error)
+
+		// Save stores the data of the session id, which expires after ttl.
+		Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+
+		// Delete deletes the session id.
+		Delete(ctx context.Context, id string) error
+	}
+
+	// SessionOptions configures the Sessions middleware.
+	SessionOptions struct {
+		// Store keeps the data of sessions. It is required.
+		Store SessionStore
+
+		// Keys sign the session ID in the cookie. The first key signs new
+		// cookies and all of them verify cookies, so keys can be rotated by
+		// adding a new key first and removing old keys once their cookies
+		// expired. At least one key is required; keys should be 32 random
+		// bytes.
+		Keys [][]byte
+
+		// EncryptionKeys encrypt the session ID in the cookie with AES-GCM
+		// when set, rotated like Keys. Keys must be 16, 24 or 32 bytes.
+		EncryptionKeys [][]byte
+
+		// CookieName is the name of the cookie. It defaults to "session".
+		CookieName string
+
+		// Path and Domain scope the cookie. Path defaults to "/".
+		Path   string
+		Domain string
+
+		// Insecure lets the cookie be sent over plain HTTP, for development.
+		Insecure bool
+
+		// SameSite is the SameSite attribute of the cookie. It defaults to
+		// Lax.
+		SameSite http.SameSite
+
+		// IdleTimeout ends sessions not used for that long. It defaults to
+		// 30 minutes.
+		IdleTimeout time.Duration
+
+		// AbsoluteTimeout ends sessions that long after they started,
+		// however much they are used. It defaults to 12 hours.
+		AbsoluteTimeout time.Duration
+	}
+
+	// Session is the session of a request. It is loaded when first used and
+	// saved before the response is sent if it was modified.
+	Session struct {
+		id      string
+		values  map[string]json.RawMessage
+		created time.Time
+		saved   time.Time
+
+		modified  bool
+		rotate    bool
+		destroyed bool
+	}
+
+	// sessionManager loads and saves the sessions of a Sessions middleware.
+	sessionManager struct {
+		o SessionOptions
+	}
+
+	// sessionRecord is the data of a session as it is stored.
+	sessionRecord struct {
+		Values  map[string]json.RawMessage `json:"values"`
+		Created time.Time                  `json:"created"`
+		Saved   time.Time                  `json:"saved"`
+	}
+
+	// sessionWriter saves the session of a request before the response is
+	// sent.
+	sessionWriter struct {
+		http.ResponseWriter
+		ctx       *Context
+		committed bool
+	}
+)
+
+// Sessions returns middleware giving requests a session, available to
+// handlers with Context.Session. The session ID is kept in a signed cookie
+// and the data of the session in the store.
+//
+// Example
+//
+//	c.Use(cobalt.Sessions(cobalt.SessionOptions{
+//		Store: cobalt.NewMemorySessionStore(),
+//		Keys:  [][]byte{sessionKey},
+//	}))
+func Sessions(o SessionOptions) MiddleWare {
+	if o.Store == nil {
+		panic("cobalt: Sessions requires a SessionStore")
+	}
+	if len(o.Keys) == 0 {
+		panic("cobalt: Sessions requires a signing key")
+	}
+	for _, k := range o.EncryptionKeys {
+		if _, err := aes.NewCipher(k); err != nil {
+			panic("cobalt: " + err.Error())
+		}
+	}
+	if o.CookieName == "" {
+		o.CookieName = defaultSessionCookie
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = defaultIdleTimeout
+	}
+	if o.AbsoluteTimeout == 0 {
+		o.AbsoluteTimeout = defaultAbsoluteTimeout
+	}
+
+	m := sessionManager{o: o}
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			prev := ctx.Response
+			sw := sessionWriter{ResponseWriter: prev, ctx: ctx}
+			ctx.Response = &sw
+			ctx.sessions = &m
+
+			defer func() {
+				ctx.Response = prev
+				if p := recover(); p != nil {
+					panic(p)
+				}
+				sw.commit()
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// Session returns the session of the request, loading it the first time. It
+// panics if the route doesn't use the Sessions middleware.
+func (c *Context) Session() *Session {
+	if c.session != nil {
+		return c.session
+	}
+	if c.sessions == nil {
+		panic("cobalt: Context.Session requires the Sessions middleware")
+	}
+
+	c.session = c.sessions.load(c)
+	return c.session
+}
+
+// load loads the session of the request from its cookie, or starts a new one.
+func (m *sessionManager) load(ctx *Context) *Session {
+	now := time.Now()
+	fresh := &Session{values: make(map[string]json.RawMessage), created: now}
+
+	cookie, err := ctx.Request.Cookie(m.o.CookieName)
+	if err != nil {
+		return fresh
+	}
+	id, err := decodeCookie(m.o.Keys, m.o.EncryptionKeys, m.o.CookieName, cookie.Value)
+	if err != nil {
+		return fresh
+	}
+
+	data, err := m.o.Store.Load(ctx.Request.Context(), string(id))
+	if err != nil {
+		ctx.Logger().Error("session load failed", Field{"error", err})
+		return fresh
+	}
+	if data == nil {
+		return fresh
+	}
+
+	var rec sessionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		ctx.Logger().Error("session load failed", Field{"error", err})
+		return fresh
+	}
+
+	s := Session{id: string(id), values: rec.Values, created: rec.Created, saved: rec.Saved}
+	if s.values == nil {
+		s.values = make(map[string]json.RawMessage)
+	}
+	if now.Sub(s.created) >= m.o.AbsoluteTimeout || now.Sub(s.saved) >= m.o.IdleTimeout {
+		m.o.Store.Delete(ctx.Request.Context(), s.id)
+		return fresh
+	}
+	return &s
+}
+
+// save saves the session of the request, setting its cookie when it has a
+// new ID. Sessions not modified are saved again once a quarter of the idle
+// timeout passed, to keep them alive.
+func (m *sessionManager) save(ctx *Context, s *Session) error {
+	now := time.Now()
+	store := m.o.Store
+	rctx := ctx.Request.Context()
+
+	if s.destroyed {
+		if s.id != "" {
+			if err := store.Delete(rctx, s.id); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(ctx.Response, m.cookie("", -1))
+		return nil
+	}
+
+	newID := s.id == "" || s.rotate
+	if !newID && !s.modified && now.Sub(s.saved) < m.o.IdleTimeout/4 {
+		return nil
+	}
+	if s.id == "" && !s.modified {
+		// Nothing was stored in the session, so no cookie is needed.
+		return nil
+	}
+
+	if newID {
+		if s.id != "" {
+			if err := store.Delete(rctx, s.id); err != nil {
+				return err
+			}
+		}
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+
+	s.saved = now
+	data, err := json.Marshal(sessionRecord{Values: s.values, Created: s.created, Saved: s.saved})
+	if err != nil {
+		return err
+	}
+	ttl := m.o.IdleTimeout
+	if left := s.created.Add(m.o.AbsoluteTimeout).Sub(now); left < ttl {
+		ttl = left
+	}
+	if err := store.Save(rctx, s.id, data, ttl); err != nil {
+		return err
+	}
+
+	if newID {
+		value, err := encodeCookie(m.o.Keys, m.o.EncryptionKeys, m.o.CookieName, []byte(s.id))
+		if err != nil {
+			return err
+		}
+		http.SetCookie(ctx.Response, m.cookie(value, 0))
+	}
+	return nil
+}
+
+// cookie returns the session cookie with value.
+func (m *sessionManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.o.CookieName,
+		Value:    value,
+		Path:     m.o.Path,
+		Domain:   m.o.Domain,
+		MaxAge:   maxAge,
+		Secure:   !m.o.Insecure,
+		HttpOnly: true,
+		SameSite: m.o.SameSite,
+	}
+}
+
+// newSessionID returns a random session ID.
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ID returns the ID of the session, which is empty until a new session is
+// saved.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get decodes the value of key into val, a pointer. It reports whether the
+// session has the key.
+func (s *Session) Get(key string, val interface{}) bool {
+	v, ok := s.values[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(v, val) == nil
+}
+
+// GetString returns the string value of key, or "" if there is none.
+func (s *Session) GetString(key string) string {
+	var v string
+	s.Get(key, &v)
+	return v
+}
+
+// Set sets the value of key. Values are stored as JSON. Values set after
+// Destroy start a new session.
+func (s *Session) Set(key string, val interface{}) error {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if s.destroyed {
+		s.destroyed = false
+		s.rotate = true
+		s.created = time.Now()
+	}
+	s.values[key] = b
+	s.modified = true
+	return nil
+}
+
+// Delete deletes the value of key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Rotate gives the session a new ID, keeping its values. It should be called
+// when the privileges of the session change, such as on login, so an ID
+// known to an attacker before can't be used.
+func (s *Session) Rotate() {
+	s.rotate = true
+	s.modified = true
+}
+
+// Destroy deletes the session and its cookie, such as on logout. Values set
+// afterwards, such as a flash message shown after logout, are kept in a new
+// session.
+func (s *Session) Destroy() {
+	s.values = make(map[string]json.RawMessage)
+	s.destroyed = true
+}
+
+// clone returns a copy of s with its own values.
+func (s *Session) clone() *Session {
+	c := *s
+	c.values = make(map[string]json.RawMessage, len(s.values))
+	for k, v := range s.values {
+		c.values[k] = v
+	}
+	return &c
+}
+
+// commit saves the session once, if it was used.
+func (sw *sessionWriter) commit() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+
+	ctx := sw.ctx
+	if ctx.session == nil {
+		return
+	}
+
+	// The cookie is set on the writer of the middleware, which may be
+	// replaced by the time the response is sent.
+	resp := ctx.Response
+	ctx.Response = sw.ResponseWriter
+	defer func() { ctx.Response = resp }()
+
+	if err := ctx.sessions.save(ctx, ctx.session); err != nil {
+		ctx.Logger().Error("session save failed", Field{"error", err})
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (sw *sessionWriter) WriteHeader(code int) {
+	if code < 100 || code >= 200 || code == http.StatusSwitchingProtocols {
+		sw.commit()
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.commit()
+	return sw.ResponseWriter.Write(b)
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (sw *sessionWriter) ReadFrom(r io.Reader) (int64, error) {
+	sw.commit()
+	if rf, ok := sw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{sw.ResponseWriter}, r)
+}
+
+// Flush implements http.Flusher.
+func (sw *sessionWriter) Flush() {
+	sw.commit()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (sw *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := sw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for
+// http.ResponseController.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,217 @@
+package cobalt_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// sessionApp returns a cobalt with sessions counting visits at /, logging in
+// at /login and out at /logout.
+func sessionApp(o cobalt.SessionOptions) *cobalt.Cobalt {
+	c := quiet(cobalt.Sessions(o))
+	c.Get("/", func(ctx *cobalt.Context) {
+		var visits int
+		ctx.Session().Get("visits", &visits)
+		ctx.Session().Set("visits", visits+1)
+		ctx.ServeResponse([]byte(strings.Repeat("v", visits+1)), http.StatusOK, "text/plain")
+	})
+	c.Get("/peek", func(ctx *cobalt.Context) {
+		ctx.ServeResponse([]byte(ctx.Session().GetString("user")), http.StatusOK, "text/plain")
+	})
+	c.Get("/login", func(ctx *cobalt.Context) {
+		ctx.Session().Rotate()
+		ctx.Session().Set("user", "ada")
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Get("/logout", func(ctx *cobalt.Context) {
+		ctx.Session().Destroy()
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Get("/goodbye", func(ctx *cobalt.Context) {
+		ctx.Session().Destroy()
+		ctx.Session().Set("user", "guest")
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Get("/static", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+	return c
+}
+
+// TestSessions tests sessions are saved when modified and kept between
+// requests.
+func TestSessions(t *testing.T) {
+	store := cobalt.NewMemorySessionStore()
+	cl := newClient(sessionApp(cobalt.SessionOptions{Store: store, Keys: [][]byte{[]byte("key-1")}}))
+
+	if set := cookieNamed(cl.get("/static"), "session"); set != nil || store.Len() != 0 {
+		t.Errorf("expected no session for requests not using it, got %v", set)
+	}
+	if set := cookieNamed(cl.get("/peek"), "session"); set != nil || store.Len() != 0 {
+		t.Errorf("expected no session when nothing was stored, got %v", set)
+	}
+
+	w := cl.get("/")
+	body, set := w.Body.String(), cookieNamed(w, "session")
+	if body != "v" || set == nil {
+		t.Fatalf("expected a new session, got %q %v", body, set)
+	}
+	if !set.HttpOnly || !set.Secure || set.SameSite != http.SameSiteLaxMode || set.Path != "/" {
+		t.Errorf("expected a secure cookie, got %+v", set)
+	}
+	if w := cl.get("/"); w.Body.String() != "vv" || cookieNamed(w, "session") != nil {
+		t.Errorf("expected the session to be kept without setting the cookie again, got %q %v", w.Body.String(), w.Header())
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected one session in the store, got %d", store.Len())
+	}
+}
+
+// TestSessionRotate tests sessions get a new ID on login and are deleted on
+// logout.
+func TestSessionRotate(t *testing.T) {
+	store := cobalt.NewMemorySessionStore()
+	cl := newClient(sessionApp(cobalt.SessionOptions{Store: store, Keys: [][]byte{[]byte("key-1")}}))
+
+	cl.get("/")
+	before := cl.cookies["session"]
+
+	if set := cookieNamed(cl.get("/login"), "session"); set == nil || set.Value == before.Value {
+		t.Fatalf("expected a new session ID on login, got %v", set)
+	}
+	if body := cl.get("/").Body.String(); body != "vv" {
+		t.Errorf("expected the values to be kept on login, got %q", body)
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected the old session to be deleted, got %d sessions", store.Len())
+	}
+
+	old := newClient(cl.c)
+	old.cookies["session"] = before
+	if body := old.get("/peek").Body.String(); body != "" {
+		t.Errorf("expected the old session ID to be rejected, got %q", body)
+	}
+
+	if set := cookieNamed(cl.get("/logout"), "session"); set == nil || set.MaxAge != -1 {
+		t.Errorf("expected the cookie to be deleted on logout, got %v", set)
+	}
+	if store.Len() != 0 {
+		t.Errorf("expected the session to be deleted on logout, got %d sessions", store.Len())
+	}
+}
+
+// TestSessionDestroySet tests values set after Destroy are kept in a new
+// session.
+func TestSessionDestroySet(t *testing.T) {
+	store := cobalt.NewMemorySessionStore()
+	cl := newClient(sessionApp(cobalt.SessionOptions{Store: store, Keys: [][]byte{[]byte("key-1")}}))
+
+	cl.get("/login")
+	before := cl.cookies["session"]
+	if set := cookieNamed(cl.get("/goodbye"), "session"); set == nil || set.MaxAge < 0 || set.Value == before.Value {
+		t.Fatalf("expected a new session, got %v", set)
+	}
+	if body := cl.get("/peek").Body.String(); body != "guest" {
+		t.Errorf("expected the value set after Destroy, got %q", body)
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected the destroyed session to be deleted, got %d sessions", store.Len())
+	}
+}
+
+// TestSessionExpiry tests sessions end after the idle and absolute timeouts.
+func TestSessionExpiry(t *testing.T) {
+	store := cobalt.NewMemorySessionStore()
+	cl := newClient(sessionApp(cobalt.SessionOptions{
+		Store:       store,
+		Keys:        [][]byte{[]byte("key-1")},
+		IdleTimeout: 50 * time.Millisecond,
+	}))
+	cl.get("/")
+	time.Sleep(80 * time.Millisecond)
+	if body := cl.get("/").Body.String(); body != "v" {
+		t.Errorf("expected the idle session to end, got %q", body)
+	}
+
+	cl = newClient(sessionApp(cobalt.SessionOptions{
+		Store:           store,
+		Keys:            [][]byte{[]byte("key-1")},
+		AbsoluteTimeout: 100 * time.Millisecond,
+	}))
+	cl.get("/")
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		cl.get("/")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if body := cl.get("/").Body.String(); body != "v" {
+		t.Errorf("expected the session to end after the absolute timeout, got %q", body)
+	}
+}
+
+// TestSessionCookie tests tampered cookies are rejected, keys can be rotated
+// and session IDs can be encrypted.
+func TestSessionCookie(t *testing.T) {
+	store := cobalt.NewMemorySessionStore()
+	o := cobalt.SessionOptions{Store: store, Keys: [][]byte{[]byte("key-1")}}
+	cl := newClient(sessionApp(o))
+	cl.get("/")
+
+	tampered := newClient(cl.c)
+	tampered.cookies["session"] = &http.Cookie{Name: "session", Value: "x" + cl.cookies["session"].Value[1:]}
+	if body := tampered.get("/").Body.String(); body != "v" {
+		t.Errorf("expected the tampered cookie to be rejected, got %q", body)
+	}
+
+	o.Keys = [][]byte{[]byte("key-2"), []byte("key-1")}
+	cl.c = sessionApp(o)
+	if body := cl.get("/").Body.String(); body != "vv" {
+		t.Errorf("expected the cookie of the old key to be accepted, got %q", body)
+	}
+	o.Keys = [][]byte{[]byte("key-2")}
+	cl.c = sessionApp(o)
+	if body := cl.get("/").Body.String(); body != "v" {
+		t.Errorf("expected the cookie of the removed key to be rejected, got %q", body)
+	}
+
+	o.EncryptionKeys = [][]byte{[]byte("0123456789abcdef0123456789abcdef")}
+	cl = newClient(sessionApp(o))
+	cl.get("/")
+	payload, _, _ := strings.Cut(cl.cookies["session"].Value, ".")
+	if b, _ := base64.RawURLEncoding.DecodeString(payload); len(b) == 43 {
+		t.Errorf("expected an encrypted session ID, got %q", b)
+	}
+	if body := cl.get("/").Body.String(); body != "vv" {
+		t.Errorf("expected the encrypted session ID to be accepted, got %q", body)
+	}
+}
+
+// TestFileSessionStore tests sessions are kept in files until they expire.
+func TestFileSessionStore(t *testing.T) {
+	store, err := cobalt.NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := newClient(sessionApp(cobalt.SessionOptions{Store: store, Keys: [][]byte{[]byte("key-1")}}))
+	cl.get("/")
+	if body := cl.get("/").Body.String(); body != "vv" {
+		t.Errorf("expected the session to be kept in the file, got %q", body)
+	}
+	cl.get("/logout")
+	if body := cl.get("/").Body.String(); body != "v" {
+		t.Errorf("expected the session file to be deleted, got %q", body)
+	}
+
+	ctx := NewRequest("GET", "/", nil).Context()
+	if err := store.Save(ctx, "old", []byte("data"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Load(ctx, "old"); err != nil || data != nil {
+		t.Errorf("expected the expired session to be ignored, got %q %v", data, err)
+	}
+}
@@ -0,0 +1,173 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore is a SessionStore keeping sessions in memory. Sessions
+// are lost when the server stops and aren't shared between servers.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	saves    int
+}
+
+// NewMemorySessionStore creates a MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memoryEntry)}
+}
+
+// Load implements SessionStore.
+func (s *MemorySessionStore) Load(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.sessions[id]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, nil
+	}
+	return e.state, nil
+}
+
+// Save implements SessionStore. Expired sessions are evicted as sessions are
+// saved.
+func (s *MemorySessionStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.saves++
+	if s.saves%sweepEvery == 0 {
+		for k, e := range s.sessions {
+			if !now.Before(e.expires) {
+				delete(s.sessions, k)
+			}
+		}
+	}
+
+	s.sessions[id] = memoryEntry{state: data, expires: now.Add(ttl)}
+	return nil
+}
+
+// Delete implements SessionStore.
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions in the store, including expired sessions
+// not evicted yet.
+func (s *MemorySessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
+
+// FileSessionStore is a SessionStore keeping sessions in files of a
+// directory, so they survive restarts. Files are named after a hash of the
+// session ID.
+type FileSessionStore struct {
+	dir string
+
+	mu    sync.Mutex
+	saves int
+}
+
+// NewFileSessionStore creates a FileSessionStore in dir, creating it if
+// needed.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path returns the path of the file of the session id.
+func (s *FileSessionStore) path(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:])+".session")
+}
+
+// Load implements SessionStore.
+func (s *FileSessionStore) Load(ctx context.Context, id string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Files start with the expiry of the session in Unix nanoseconds.
+	if len(b) < 8 || time.Now().UnixNano() >= int64(binary.BigEndian.Uint64(b)) {
+		return nil, nil
+	}
+	return b[8:], nil
+}
+
+// Save implements SessionStore. Files are replaced atomically, and expired
+// files are removed as sessions are saved.
+func (s *FileSessionStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	s.saves++
+	sweep := s.saves%sweepEvery == 0
+	s.mu.Unlock()
+	if sweep {
+		s.sweep()
+	}
+
+	f, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var expires [8]byte
+	binary.BigEndian.PutUint64(expires[:], uint64(time.Now().Add(ttl).UnixNano()))
+	if _, err := f.Write(append(expires[:], data...)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(id))
+}
+
+// Delete implements SessionStore.
+func (s *FileSessionStore) Delete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// sweep removes the files of expired sessions.
+func (s *FileSessionStore) sweep() {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.session"))
+	now := time.Now().UnixNano()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		var expires [8]byte
+		_, err = f.Read(expires[:])
+		f.Close()
+		if err == nil && now >= int64(binary.BigEndian.Uint64(expires[:])) {
+			os.Remove(p)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"html/template"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 // Templates handles the compiling and execution of templates.
@@ -14,7 +15,33 @@ type Templates struct {
 	Development bool   // Set to true to enable recompilation on each request
 	Funcs       template.FuncMap
 
-	cache map[string]*template.Template
+	cache map[string]*compiledTemplate
+}
+
+// compiledTemplate is a parsed template with copies of it to execute, which
+// requests bind their template functions to. Copies are reused, so templates
+// aren't cloned for every request.
+type compiledTemplate struct {
+	tmpl   *template.Template
+	copies sync.Pool
+}
+
+// contextFuncs are the template functions of requests, available in every
+// template. ServeHTML binds them to the request; they return empty values in
+// templates executed otherwise.
+var contextFuncs = template.FuncMap{
+	"csrfToken": func() string { return "" },
+	"csrfField": func() template.HTML { return "" },
+	"flashes":   func(categories ...string) []Flash { return nil },
+}
+
+// templateFuncs returns the template functions bound to the request c.
+func (c *Context) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": c.CSRFToken,
+		"csrfField": c.CSRFField,
+		"flashes":   c.Flashes,
+	}
 }
 
 // DefaultTemplates creates a Templates set with default values.
@@ -25,14 +52,14 @@ func DefaultTemplates() Templates {
 		Layout:      "_layout",
 		Development: false,
 		Funcs:       make(template.FuncMap),
-		cache:       make(map[string]*template.Template),
+		cache:       make(map[string]*compiledTemplate),
 	}
 }
 
-func (t Templates) lookup(layout, name string) (*template.Template, error) {
+func (t Templates) lookup(layout, name string) (*compiledTemplate, error) {
 	if !t.Development {
-		if tmp, ok := t.cache[layout+name]; ok {
-			return tmp, nil
+		if ct, ok := t.cache[layout+name]; ok {
+			return ct, nil
 		}
 	}
 
@@ -40,7 +67,7 @@ func (t Templates) lookup(layout, name string) (*template.Template, error) {
 
 	if layout != "" {
 		// Compile layout to get a base template
-		l, err := template.New(t.Layout + t.Extension).Funcs(t.Funcs).ParseFiles(filepath.Join(t.Directory, t.Layout+t.Extension))
+		l, err := template.New(t.Layout + t.Extension).Funcs(contextFuncs).Funcs(t.Funcs).ParseFiles(filepath.Join(t.Directory, t.Layout+t.Extension))
 		if err != nil {
 			return nil, err
 		}
@@ -54,37 +81,62 @@ func (t Templates) lookup(layout, name string) (*template.Template, error) {
 	} else {
 		var err error
 
-		tmp, err = template.New(filepath.Base(name) + t.Extension).Funcs(t.Funcs).ParseFiles(filepath.Join(t.Directory, name+t.Extension))
+		tmp, err = template.New(filepath.Base(name) + t.Extension).Funcs(contextFuncs).Funcs(t.Funcs).ParseFiles(filepath.Join(t.Directory, name+t.Extension))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	t.cache[layout+name] = tmp
+	ct := &compiledTemplate{tmpl: tmp}
+	t.cache[layout+name] = ct
 
-	return tmp, nil
+	return ct, nil
 }
 
 // Execute will load the layout and the named template then execute them
 // against the provided writer.
 func (t Templates) Execute(w io.Writer, name string, data interface{}) error {
-
-	tmp, err := t.lookup(t.Layout, name)
-	if err != nil {
-		return err
-	}
-
-	return tmp.Execute(w, data)
+	return t.execute(w, t.Layout, name, data, nil)
 }
 
 // ExecuteOnly will load the named template ignoring the layout file. It is
 // then executed against the provided writer.
 func (t Templates) ExecuteOnly(w io.Writer, name string, data interface{}) error {
+	return t.execute(w, "", name, data, nil)
+}
+
+// execute executes the named template with the layout, if any, and funcs
+// replacing template functions. A copy of the cached template is executed so
+// the functions of one request aren't seen by others.
+func (t Templates) execute(w io.Writer, layout, name string, data interface{}, funcs template.FuncMap) error {
+	ct, err := t.lookup(layout, name)
+	if err != nil {
+		return err
+	}
 
-	tmp, err := t.lookup("", name)
+	tmp, err := ct.get()
 	if err != nil {
 		return err
 	}
+	defer ct.put(tmp)
 
+	if funcs != nil {
+		tmp.Funcs(funcs)
+	}
 	return tmp.Execute(w, data)
 }
+
+// get returns a copy of the template no one else is executing.
+func (ct *compiledTemplate) get() (*template.Template, error) {
+	if tmp, ok := ct.copies.Get().(*template.Template); ok {
+		return tmp, nil
+	}
+	return ct.tmpl.Clone()
+}
+
+// put returns a copy of the template to be reused, with the functions of the
+// request it was executed for unbound.
+func (ct *compiledTemplate) put(tmp *template.Template) {
+	tmp.Funcs(contextFuncs)
+	ct.copies.Put(tmp)
+}
@@ -0,0 +1,200 @@
+package cobalt
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutOptions controls how the Timeout middleware responds when a handler
+// overruns.
+type TimeoutOptions struct {
+	// Status is the HTTP status code served on timeout. It defaults to 503,
+	// use 504 when the handler is waiting on an upstream.
+	Status int
+
+	// Body is encoded with the Coder and served on timeout. It defaults to
+	// a message saying the request timed out.
+	Body interface{}
+
+	// OnTimeout is called after the timeout response is served so the
+	// timeout can be reported, for example to metrics.
+	OnTimeout func(ctx *Context, d time.Duration)
+}
+
+// timeoutBody is the default body served on timeout.
+type timeoutBody struct {
+	Error string
+}
+
+// Timeout returns middleware that bounds the execution of handlers to d. The
+// deadline is attached to Request.Context() so handlers can stop work early.
+// If the handler has not finished by the deadline a timeout response is
+// served and anything the abandoned handler writes after that is discarded.
+// The response of the handler is buffered until it returns, so Timeout is not
+// suited to streaming responses. The state the handler sets on the Context,
+// such as its session or identity, is kept if it finishes in time. It can be
+// added to a route, or to every route with Use. You may also provide a single
+// optional argument of type TimeoutOptions to customize the response.
+//
+// Example
+//
+//	c.Get("/report", report, cobalt.Timeout(5*time.Second))
+func Timeout(d time.Duration, options ...TimeoutOptions) MiddleWare {
+	var op TimeoutOptions
+	if len(options) > 0 {
+		op = options[0]
+	}
+	if op.Status == 0 {
+		op.Status = http.StatusServiceUnavailable
+	}
+	if op.Body == nil {
+		op.Body = timeoutBody{Error: "request timed out"}
+	}
+
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			tctx, cancel := context.WithTimeout(ctx.Request.Context(), d)
+			defer cancel()
+
+			tw := timeoutWriter{h: make(http.Header)}
+			hctx := handlerContext(ctx, ctx.Request.WithContext(tctx), &tw)
+
+			done := make(chan struct{})
+			panicChan := make(chan handlerPanic, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- handlerPanic{value: p, stack: debug.Stack()}
+					}
+				}()
+				h(hctx)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				// Panic on the request goroutine so it is recovered as usual,
+				// with the stack of the handler.
+				panic(p)
+
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+
+				// The state set by the handler, such as its session or
+				// identity, is kept before the response is written, as
+				// middleware may save it then.
+				req, resp, w := ctx.Request, ctx.Response, ctx.writer
+				*ctx = *hctx
+				ctx.Request, ctx.Response, ctx.writer = req, resp, w
+
+				dst := ctx.Response.Header()
+				for k, v := range tw.h {
+					dst[k] = v
+				}
+				if tw.code != 0 {
+					ctx.Response.WriteHeader(tw.code)
+				}
+				ctx.Response.Write(tw.buf.Bytes())
+
+			case <-tctx.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+
+				// The client went away, there is no one to respond to.
+				if tctx.Err() != context.DeadlineExceeded {
+					return
+				}
+
+				ctx.Logger().Error("request timed out",
+					Field{"method", ctx.Request.Method},
+					Field{"path", ctx.Request.RequestURI},
+					Field{"timeout", d},
+				)
+				ctx.ServeWithStatus(op.Body, op.Status)
+				if op.OnTimeout != nil {
+					op.OnTimeout(ctx, d)
+				}
+			}
+		}
+	}
+}
+
+// handlerPanic is the panic of a handler run by Timeout, with the stack of its
+// goroutine.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// handlerContext returns the copy of ctx a handler run by Timeout runs
+// against, serving req and writing to w. It has its own data, session,
+// identity and flashes, so the handler doesn't race with ctx once it is
+// abandoned. Spans are safe for concurrent use and shared.
+func handlerContext(ctx *Context, req *http.Request, w http.ResponseWriter) *Context {
+	hctx := *ctx
+	hctx.Request = req
+	hctx.writer = newResponseWriter(w)
+	hctx.Response = hctx.writer
+
+	hctx.data = make(map[string]interface{}, len(ctx.data))
+	for k, v := range ctx.data {
+		hctx.data[k] = v
+	}
+	if ctx.session != nil {
+		hctx.session = ctx.session.clone()
+	}
+	if ctx.identity != nil {
+		id := *ctx.identity
+		hctx.identity = &id
+	}
+	hctx.policies = ctx.policies[:len(ctx.policies):len(ctx.policies)]
+	hctx.flashes = ctx.flashes.clone()
+	return &hctx
+}
+
+// timeoutWriter buffers the response of a handler run by Timeout. Once the
+// timeout is hit all writes fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	h http.Header
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+// Header implements http.ResponseWriter.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// Write implements http.ResponseWriter.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
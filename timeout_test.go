@@ -0,0 +1,145 @@
+package cobalt_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestTimeout tests a handler overrunning its timeout.
+func TestTimeout(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	late := make(chan error, 1)
+	timedOut := make(chan struct{})
+	var reported time.Duration
+
+	c := cobalt.New(&JSONEncoder{})
+	c.Use(cobalt.Timeout(10*time.Millisecond, cobalt.TimeoutOptions{
+		Status: http.StatusGatewayTimeout,
+		Body:   struct{ Message string }{"Too Slow"},
+		OnTimeout: func(ctx *cobalt.Context, d time.Duration) {
+			reported = d
+			close(timedOut)
+		},
+	}))
+	c.Get("/", func(ctx *cobalt.Context) {
+		<-timedOut
+		if ctx.Request.Context().Err() == nil {
+			t.Error("expected request context to be done")
+		}
+		_, err := ctx.Response.Write([]byte("late"))
+		late <- err
+		_, err = ctx.Writer().Write([]byte("LATE"))
+		late <- err
+	})
+
+	c.ServeHTTP(w, r)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status code to be 504 instead got %d", w.Code)
+	}
+
+	var msg struct{ Message string }
+	json.Unmarshal(w.Body.Bytes(), &msg)
+	if msg.Message != "Too Slow" {
+		t.Errorf("expected body to be Too Slow instead got %s", w.Body.String())
+	}
+	if reported != 10*time.Millisecond {
+		t.Errorf("expected timeout to be reported, got %v", reported)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-late; err != http.ErrHandlerTimeout {
+			t.Errorf("expected late write %d to fail with ErrHandlerTimeout, got %v", i, err)
+		}
+	}
+	if strings.Contains(w.Body.String(), "LATE") {
+		t.Errorf("expected late writes to be discarded, got %s", w.Body.String())
+	}
+}
+
+// TestTimeoutFinished tests a handler finishing within its timeout.
+func TestTimeoutFinished(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	c := cobalt.New(&JSONEncoder{})
+	c.Get("/", func(ctx *cobalt.Context) {
+		if _, ok := ctx.Request.Context().Deadline(); !ok {
+			t.Error("expected request context to have a deadline")
+		}
+		ctx.ServeWithStatus(struct{ Message string }{"Created"}, http.StatusCreated)
+	}, cobalt.Timeout(time.Second))
+
+	c.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status code to be 201 instead got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != (JSONEncoder{}).ContentType() {
+		t.Errorf("expected content type to be set, got %s", ct)
+	}
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Error("expected request id header to be kept")
+	}
+}
+
+// TestTimeoutPanic tests a panic in a handler run by Timeout is recovered.
+func TestTimeoutPanic(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	var report cobalt.PanicReport
+	c := quiet()
+	c.Recovery.Reporter = func(r cobalt.PanicReport) {
+		report = r
+	}
+	c.Get("/", func(ctx *cobalt.Context) {
+		panic("Panic Test")
+	}, cobalt.Timeout(time.Second))
+
+	c.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code to be 500 instead got %d", w.Code)
+	}
+	if report.Value != "Panic Test" || !strings.Contains(string(report.Stack), "TestTimeoutPanic.func") {
+		t.Errorf("expected the panic to be reported with the stack of the handler, got %v\n%s", report.Value, report.Stack)
+	}
+}
+
+// TestTimeoutAbandoned tests an abandoned handler using its session and
+// flashes doesn't race with the request saving them.
+func TestTimeoutAbandoned(t *testing.T) {
+	finished := make(chan struct{})
+	c := quiet(cobalt.Sessions(cobalt.SessionOptions{
+		Store: cobalt.NewMemorySessionStore(),
+		Keys:  [][]byte{[]byte("key-1")},
+	}))
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.Flash("info", "started")
+		time.Sleep(50 * time.Millisecond)
+		for i := 0; i < 100; i++ {
+			ctx.Session().Set("step", i)
+			ctx.Flash("info", "step")
+		}
+		close(finished)
+	}, cobalt.Timeout(10*time.Millisecond), func(h cobalt.Handler) cobalt.Handler {
+		return func(ctx *cobalt.Context) {
+			ctx.Session().Set("user", "gopher")
+			h(ctx)
+		}
+	})
+
+	w := get(c, "/")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code to be 503 instead got %d", w.Code)
+	}
+	<-finished
+}
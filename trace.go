@@ -0,0 +1,364 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace context headers defined by the W3C Trace Context recommendation.
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// Span kinds.
+const (
+	SpanKindInternal = "internal"
+	SpanKindServer   = "server"
+)
+
+type (
+	// TraceID identifies a trace.
+	TraceID [16]byte
+
+	// SpanID identifies a span within a trace.
+	SpanID [8]byte
+
+	// SpanContext is the part of a span that is propagated between services
+	// in the traceparent and tracestate headers.
+	SpanContext struct {
+		TraceID    TraceID
+		SpanID     SpanID
+		Sampled    bool
+		TraceState string
+	}
+
+	// SpanData is a finished span as handed to an Exporter.
+	SpanData struct {
+		Name string
+		Kind string
+		SpanContext
+		Parent     SpanID
+		Start      time.Time
+		End        time.Time
+		Attributes map[string]interface{}
+
+		// Error is set when the operation failed, with a description in
+		// StatusMessage.
+		Error         bool
+		StatusMessage string
+	}
+
+	// Exporter sends finished spans to a tracing backend.
+	Exporter interface {
+		ExportSpans(spans []SpanData) error
+	}
+
+	// Tracer creates spans and exports them once they end.
+	Tracer struct {
+		exporter Exporter
+	}
+
+	// Span is an operation being traced. A nil *Span is valid and does
+	// nothing, so code can trace without checking if tracing is enabled.
+	Span struct {
+		tracer *Tracer
+
+		mu    sync.Mutex
+		data  SpanData
+		ended bool
+	}
+)
+
+// String returns the trace ID as hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// String returns the span ID as hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// NewTracer creates a Tracer exporting spans to exp.
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// Start starts a span. It is a child of parent when parent is valid,
+// otherwise it starts a new sampled trace.
+func (t *Tracer) Start(name, kind string, parent SpanContext) *Span {
+	s := Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+
+	if parent.TraceID != (TraceID{}) {
+		s.data.SpanContext = parent
+		s.data.Parent = parent.SpanID
+	} else {
+		rand.Read(s.data.TraceID[:])
+		s.data.Sampled = true
+	}
+	rand.Read(s.data.SpanID[:])
+
+	return &s
+}
+
+// SpanContext returns the propagated context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records an attribute of the operation. It does nothing once
+// the span ended.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+	s.mu.Unlock()
+}
+
+// SetError marks the operation as failed. It does nothing once the span
+// ended.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.ended {
+		s.data.Error = true
+		s.data.StatusMessage = msg
+	}
+	s.mu.Unlock()
+}
+
+// StartChild starts a span for an operation within this one.
+func (s *Span) StartChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(name, SpanKindInternal, s.data.SpanContext)
+}
+
+// End finishes the span and exports it if it is sampled. Only the first call
+// has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	if data.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpans([]SpanData{data})
+	}
+}
+
+// Tracing returns middleware that traces every request with t. The trace is
+// continued from the traceparent and tracestate headers when the request has
+// them. The span records the route pattern, status and timing of the request
+// and is available to handlers from Context.Span, while Context.StartSpan
+// starts child spans.
+//
+// Example
+//
+//	c.Use(cobalt.Tracing(cobalt.NewTracer(exporter)))
+func Tracing(t *Tracer) MiddleWare {
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			req := ctx.Request
+			parent, _ := ParseTraceparent(req.Header.Get(traceparentHeader))
+			if parent.TraceID != (TraceID{}) {
+				parent.TraceState = strings.TrimSpace(req.Header.Get(tracestateHeader))
+			}
+
+			route := ctx.Route()
+			if route == "" {
+				route = req.URL.Path
+			}
+
+			span := t.Start(req.Method+" "+route, SpanKindServer, parent)
+			span.SetAttribute("http.method", req.Method)
+			span.SetAttribute("http.route", ctx.Route())
+			span.SetAttribute("http.target", req.RequestURI)
+			span.SetAttribute("http.request_id", ctx.ID)
+
+			ctx.span = span
+			ctx.Request = req.WithContext(context.WithValue(req.Context(), spanKey{}, span))
+
+			defer func() {
+				// Handlers writing no response send 200 OK.
+				status := ctx.Writer().Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				p := recover()
+				if p != nil {
+					status = http.StatusInternalServerError
+					span.SetError("panic")
+				}
+
+				span.SetAttribute("http.status_code", status)
+				if status >= 500 && p == nil {
+					span.SetError(http.StatusText(status))
+				}
+				span.End()
+
+				if p != nil {
+					panic(p)
+				}
+			}()
+
+			h(ctx)
+		}
+	}
+}
+
+// spanKey is the key for the request span in a context.Context.
+type spanKey struct{}
+
+// SpanFromContext returns the span stored in the context of a request traced
+// by the Tracing middleware, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Span returns the span of the request, or nil if it is not traced.
+func (c *Context) Span() *Span {
+	return c.span
+}
+
+// StartSpan starts a span for an operation within the request. It must be
+// ended by calling End. It returns nil, which is safe to use, when the request
+// is not traced.
+func (c *Context) StartSpan(name string) *Span {
+	return c.span.StartChild(name)
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers for the span
+// stored in ctx, so the trace continues in the services called with h.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+
+	sc := s.SpanContext()
+	h.Set(traceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+// ParseTraceparent parses the value of a traceparent header. It returns false
+// if the value is not valid.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+
+	// version-traceid-parentid-flags, later versions may append fields.
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, false
+	}
+	if len(v) > 55 && (v[:2] == "00" || v[55] != '-') {
+		return sc, false
+	}
+
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(v[0:2])); err != nil || version[0] == 0xff {
+		return sc, false
+	}
+	if !lowerHex(v[:55]) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(v[3:35])); err != nil || sc.TraceID == (TraceID{}) {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(v[36:52])); err != nil || sc.SpanID == (SpanID{}) {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(v[53:55])); err != nil {
+		return SpanContext{}, false
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// lowerHex reports whether the hex digits in v, ignoring dashes, are lower
+// case as the recommendation requires.
+func lowerHex(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; b >= 'A' && b <= 'F' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatTraceparent returns the traceparent header value for sc.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// InMemoryExporter keeps exported spans in memory. It is meant for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpans implements Exporter.
+func (e *InMemoryExporter) ExportSpans(spans []SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+// Spans returns the spans exported so far.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset forgets the spans exported so far.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
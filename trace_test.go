@@ -0,0 +1,257 @@
+package cobalt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestTracingContinuesTrace tests a request with a traceparent continues the
+// trace and that handlers can start child spans.
+func TestTracingContinuesTrace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	r := NewRequest("GET", "/users/42", nil)
+	r.Header.Set("traceparent", parent)
+	r.Header.Set("tracestate", "vendor=value")
+	w := httptest.NewRecorder()
+
+	exp := &cobalt.InMemoryExporter{}
+	var outbound http.Header
+
+	c := cobalt.New(&JSONEncoder{})
+	c.DisableRequestLog = true
+	c.Use(cobalt.Tracing(cobalt.NewTracer(exp)))
+	c.Get("/users/:id", func(ctx *cobalt.Context) {
+		span := ctx.StartSpan("load user")
+		span.SetAttribute("user.id", "42")
+		span.End()
+
+		outbound = make(http.Header)
+		cobalt.InjectTraceContext(ctx.Request.Context(), outbound)
+		ctx.ServeStatus(http.StatusOK)
+	})
+
+	c.ServeHTTP(w, r)
+
+	spans := exp.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+
+	if server.Name != "GET /users/:id" || server.Kind != cobalt.SpanKindServer {
+		t.Errorf("expected server span named after the route, got %s %s", server.Kind, server.Name)
+	}
+	if server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("expected span to continue the trace, got %s %s", server.TraceID, server.Parent)
+	}
+	if server.TraceState != "vendor=value" {
+		t.Errorf("expected tracestate to be kept, got %s", server.TraceState)
+	}
+	if server.Attributes["http.route"] != "/users/:id" || server.Attributes["http.status_code"] != 200 {
+		t.Errorf("expected route and status attributes, got %v", server.Attributes)
+	}
+	if server.End.Before(server.Start) || server.Error {
+		t.Errorf("expected a successful timed span, got %+v", server)
+	}
+
+	if child.Parent != server.SpanID || child.TraceID != server.TraceID || child.Attributes["user.id"] != "42" {
+		t.Errorf("expected child span of the server span, got %+v", child)
+	}
+
+	sc, ok := cobalt.ParseTraceparent(outbound.Get("traceparent"))
+	if !ok || sc.SpanID != server.SpanID || sc.TraceID != server.TraceID || !sc.Sampled {
+		t.Errorf("expected outbound traceparent for the server span, got %s", outbound.Get("traceparent"))
+	}
+}
+
+// TestTracingNewTrace tests a new trace is started for requests without a
+// valid traceparent and errors are recorded.
+func TestTracingNewTrace(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+
+	exp := &cobalt.InMemoryExporter{}
+
+	c := cobalt.New(&JSONEncoder{})
+	c.DisableRequestLog = true
+	c.Use(cobalt.Tracing(cobalt.NewTracer(exp)))
+	c.Get("/", func(ctx *cobalt.Context) {
+		ctx.ServeStatus(http.StatusServiceUnavailable)
+	})
+
+	c.ServeHTTP(w, r)
+
+	spans := exp.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Parent != (cobalt.SpanID{}) || spans[0].TraceID == (cobalt.TraceID{}) {
+		t.Errorf("expected a new root span, got %+v", spans[0])
+	}
+	if !spans[0].Error {
+		t.Error("expected the span to record the error status")
+	}
+}
+
+// TestSpanEnded tests spans are exported with their state when they end and
+// ignore changes after it.
+func TestSpanEnded(t *testing.T) {
+	exp := &cobalt.InMemoryExporter{}
+	span := cobalt.NewTracer(exp).Start("GET /orders", cobalt.SpanKindServer, cobalt.SpanContext{})
+	span.SetAttribute("http.status_code", 200)
+	span.End()
+
+	span.SetAttribute("http.status_code", 500)
+	span.SetAttribute("late", true)
+	span.SetError("Internal Server Error")
+	span.End()
+
+	spans := exp.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if attrs := spans[0].Attributes; len(attrs) != 1 || attrs["http.status_code"] != 200 || spans[0].Error {
+		t.Errorf("expected the span as it ended, got %+v", spans[0])
+	}
+}
+
+// TestParseTraceparent tests parsing traceparent values.
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"garbage", false},
+	}
+
+	for _, tt := range tests {
+		sc, ok := cobalt.ParseTraceparent(tt.value)
+		if ok != tt.ok {
+			t.Errorf("%s: expected ok to be %t", tt.value, tt.ok)
+		}
+		if ok && strings.HasPrefix(tt.value, "00") && cobalt.FormatTraceparent(sc) != tt.value {
+			t.Errorf("%s: expected to format back, got %s", tt.value, cobalt.FormatTraceparent(sc))
+		}
+	}
+}
+
+// TestOTLPExporter tests spans are posted to a collector as OTLP JSON.
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer collector.Close()
+
+	exp := &cobalt.OTLPExporter{Endpoint: collector.URL + "/v1/traces", ServiceName: "orders"}
+	tracer := cobalt.NewTracer(exp)
+
+	span := tracer.Start("GET /orders", cobalt.SpanKindServer, cobalt.SpanContext{})
+	span.SetAttribute("http.status_code", 500)
+	span.SetError("Internal Server Error")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error shutting down, got %v", err)
+	}
+
+	body := <-received
+	rs := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	attr := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if attr["key"] != "service.name" || attr["value"].(map[string]interface{})["stringValue"] != "orders" {
+		t.Errorf("expected service.name resource attribute, got %v", attr)
+	}
+
+	s := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if s["name"] != "GET /orders" || s["kind"] != float64(2) || len(s["traceId"].(string)) != 32 || len(s["spanId"].(string)) != 16 {
+		t.Errorf("unexpected span %v", s)
+	}
+	if s["status"].(map[string]interface{})["code"] != float64(2) {
+		t.Errorf("expected error status, got %v", s["status"])
+	}
+	if start, _ := s["startTimeUnixNano"].(string); start == "" || start == "0" {
+		t.Errorf("expected start time, got %v", s["startTimeUnixNano"])
+	}
+}
+
+// TestOTLPExporterRetry tests spans that couldn't be sent are sent again and
+// the oldest spans are dropped when too many are waiting.
+func TestOTLPExporterRetry(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+	var names []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if posts++; posts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct{ Name string }
+				}
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, s := range body.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, s.Name)
+		}
+	}))
+	defer collector.Close()
+
+	exp := &cobalt.OTLPExporter{
+		Endpoint:  collector.URL,
+		BatchSize: 1,
+		Interval:  10 * time.Millisecond,
+		MaxQueue:  2,
+	}
+	exp.ExportSpans([]cobalt.SpanData{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(names)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error shutting down, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(names, ",") != "b,c" || exp.Dropped() != 1 {
+		t.Errorf("expected b and c to be sent and a dropped, got %v and %d dropped", names, exp.Dropped())
+	}
+}
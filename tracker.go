@@ -0,0 +1,130 @@
+package cobalt
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Tracker keeps track of the open connections and the in flight requests
+	// of a Cobalt. Connections are tracked for the servers started by Run,
+	// RunTLS and RunServer.
+	Tracker struct {
+		mu       sync.Mutex
+		conns    map[net.Conn]http.ConnState
+		requests map[*Context]InFlight
+	}
+
+	// InFlight describes a request that is still being processed.
+	InFlight struct {
+		ID         string
+		Method     string
+		Path       string
+		RemoteAddr string
+		Start      time.Time
+		Age        time.Duration
+	}
+
+	// TrackerStats is a snapshot of the connections and requests of a Tracker.
+	TrackerStats struct {
+		// Connections counts the open connections by state, for example
+		// "active" or "idle".
+		Connections map[string]int
+
+		// Requests are the in flight requests, oldest first.
+		Requests []InFlight
+	}
+)
+
+// newTracker creates an empty Tracker.
+func newTracker() *Tracker {
+	return &Tracker{
+		conns:    make(map[net.Conn]http.ConnState),
+		requests: make(map[*Context]InFlight),
+	}
+}
+
+// Tracker returns the Tracker for the connections and requests served by c.
+func (c *Cobalt) Tracker() *Tracker {
+	return c.tracker
+}
+
+// connState records the state of a connection. It has the signature of
+// http.Server.ConnState.
+func (t *Tracker) connState(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, conn)
+	default:
+		t.conns[conn] = state
+	}
+}
+
+// start records the start of a request. The request is copied, as
+// middleware may replace it while Stats runs.
+func (t *Tracker) start(ctx *Context, st time.Time) {
+	r := InFlight{
+		ID:         ctx.ID,
+		Method:     ctx.Request.Method,
+		Path:       ctx.Request.URL.Path,
+		RemoteAddr: ctx.Request.RemoteAddr,
+		Start:      st,
+	}
+
+	t.mu.Lock()
+	t.requests[ctx] = r
+	t.mu.Unlock()
+}
+
+// done records the end of a request.
+func (t *Tracker) done(ctx *Context) {
+	t.mu.Lock()
+	delete(t.requests, ctx)
+	t.mu.Unlock()
+}
+
+// Stats returns a snapshot of the open connections and in flight requests.
+func (t *Tracker) Stats() TrackerStats {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := TrackerStats{
+		Connections: make(map[string]int),
+		Requests:    make([]InFlight, 0, len(t.requests)),
+	}
+
+	for _, state := range t.conns {
+		stats.Connections[state.String()]++
+	}
+
+	for _, r := range t.requests {
+		r.Age = now.Sub(r.Start)
+		stats.Requests = append(stats.Requests, r)
+	}
+
+	sort.Slice(stats.Requests, func(i, j int) bool {
+		return stats.Requests[i].Start.Before(stats.Requests[j].Start)
+	})
+
+	return stats
+}
+
+// Handler returns a Handler serving the Tracker stats. It is usually added to
+// an admin server.
+//
+// Example
+//
+//	admin.Get("/debug/requests", app.Tracker().Handler())
+func (t *Tracker) Handler() Handler {
+	return func(ctx *Context) {
+		ctx.Serve(t.Stats())
+	}
+}
@@ -0,0 +1,80 @@
+package cobalt_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestTrackerInFlight tests in flight requests and connections are reported
+// by the tracker handler.
+func TestTrackerInFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	app := cobalt.New(&JSONEncoder{})
+	app.Get("/slow", func(ctx *cobalt.Context) {
+		<-release
+		ctx.Response.Write([]byte("done"))
+	})
+
+	admin := cobalt.New(&JSONEncoder{})
+	admin.Get("/debug/requests", app.Tracker().Handler())
+
+	pub := listen(t)
+	adm := listen(t)
+	app.AddServer(cobalt.Server{Listener: pub})
+	app.AddServer(cobalt.Server{Listener: adm, Handler: admin})
+
+	done := make(chan struct{})
+	go func() {
+		app.Run("127.0.0.1:0", time.Second, time.Second)
+		close(done)
+	}()
+
+	admURL := "http://" + adm.Addr().String() + "/debug/requests"
+	waitFor(t, plain, admURL).Body.Close()
+
+	slow := make(chan string)
+	go func() {
+		resp, err := plain.Get("http://" + pub.Addr().String() + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		slow <- body(t, resp)
+	}()
+
+	var stats cobalt.TrackerStats
+	for i := 0; i < 100; i++ {
+		resp := waitFor(t, plain, admURL)
+		stats = cobalt.TrackerStats{}
+		json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if len(stats.Requests) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(stats.Requests) != 1 {
+		t.Fatalf("expected 1 request in flight, got %d", len(stats.Requests))
+	}
+	if r := stats.Requests[0]; r.Method != "GET" || r.Path != "/slow" || r.ID == "" {
+		t.Errorf("expected GET /slow with an ID, got %+v", r)
+	}
+	if stats.Connections["active"] != 1 {
+		t.Errorf("expected 1 active connection, got %v", stats.Connections)
+	}
+
+	close(release)
+	if got := <-slow; got != "done" {
+		t.Errorf("expected body to be done instead got %s", got)
+	}
+	if n := len(app.Tracker().Stats().Requests); n != 0 {
+		t.Errorf("expected no requests in flight, got %d", n)
+	}
+
+	stop(t, done)
+}
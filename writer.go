@@ -0,0 +1,164 @@
+package cobalt
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter wraps the http.ResponseWriter of a request and records what
+// is written to it, so the status and size of a response are known however it
+// was written. Context.Writer returns it for the whole request. Middleware
+// such as Compress and Sessions replaces the Response of a Context with
+// writers of its own, so use Context.Writer rather than asserting the type of
+// Context.Response. The http.Flusher, http.Hijacker and io.ReaderFrom
+// interfaces are passed through to the wrapped writer and Unwrap allows
+// http.ResponseController to reach it.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	io.ReaderFrom
+
+	// Status returns the status code sent, or 0 if nothing was sent yet.
+	Status() int
+
+	// Size returns the number of body bytes written.
+	Size() int64
+
+	// Written reports whether the headers have been sent.
+	Written() bool
+
+	// TimeToFirstByte returns the time from the start of the request until
+	// the headers were sent, or 0 if they were not sent yet.
+	TimeToFirstByte() time.Duration
+
+	// Unwrap returns the wrapped http.ResponseWriter.
+	Unwrap() http.ResponseWriter
+}
+
+// responseWriter is the ResponseWriter used by cobalt.
+type responseWriter struct {
+	http.ResponseWriter
+
+	start  time.Time
+	first  time.Time
+	status int
+	size   int64
+}
+
+// newResponseWriter wraps w, unless it is a ResponseWriter already.
+func newResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w, start: time.Now()}
+}
+
+// record records the status code of the response the first time headers
+// are sent.
+func (w *responseWriter) record(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.first = time.Now()
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseWriter) WriteHeader(code int) {
+
+	// Informational responses, other than switching protocols, are not the
+	// final response and can be followed by another status.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.record(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.record(http.StatusOK)
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom, using the wrapped writer's
+// implementation when it has one.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.record(http.StatusOK)
+
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, r)
+	}
+	w.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher. It sends the headers, and does nothing more
+// if the wrapped writer can not flush.
+func (w *responseWriter) Flush() {
+	w.record(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. It returns http.ErrNotSupported if the
+// wrapped writer can not be hijacked.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.record(http.StatusSwitchingProtocols)
+	}
+	return conn, rw, err
+}
+
+// Status implements ResponseWriter.
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// Size implements ResponseWriter.
+func (w *responseWriter) Size() int64 {
+	return w.size
+}
+
+// Written implements ResponseWriter.
+func (w *responseWriter) Written() bool {
+	return w.status != 0
+}
+
+// TimeToFirstByte implements ResponseWriter.
+func (w *responseWriter) TimeToFirstByte() time.Duration {
+	if w.first.IsZero() {
+		return 0
+	}
+	return w.first.Sub(w.start)
+}
+
+// Unwrap implements ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writerOnly hides any ReadFrom method of the writer so io.Copy doesn't call
+// back into it.
+type writerOnly struct {
+	io.Writer
+}
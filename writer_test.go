@@ -0,0 +1,89 @@
+package cobalt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/cobalt"
+)
+
+// TestResponseWriterRecords tests the status and size of a response written
+// directly to the response are recorded and logged.
+func TestResponseWriterRecords(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	var buf bytes.Buffer
+	var ctx *cobalt.Context
+
+	c := cobalt.New(&JSONEncoder{})
+	c.Logger = cobalt.NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	c.Get("/", func(c *cobalt.Context) {
+		ctx = c
+		if c.Writer().Written() {
+			t.Error("expected nothing to be written yet")
+		}
+		c.Response.WriteHeader(http.StatusAccepted)
+		c.Response.Write([]byte("hello"))
+	})
+
+	c.ServeHTTP(w, r)
+
+	rw := ctx.Writer()
+	if rw.Status() != http.StatusAccepted || !rw.Written() {
+		t.Errorf("expected status 202 to be recorded, got %d", rw.Status())
+	}
+	if rw.Size() != 5 {
+		t.Errorf("expected 5 bytes to be recorded, got %d", rw.Size())
+	}
+	if rw.TimeToFirstByte() <= 0 {
+		t.Errorf("expected time to first byte, got %v", rw.TimeToFirstByte())
+	}
+	if ctx.Status != http.StatusAccepted {
+		t.Errorf("expected context status to be 202, got %d", ctx.Status)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var done map[string]interface{}
+	json.Unmarshal([]byte(lines[len(lines)-1]), &done)
+	if done["status"] != float64(202) || done["bytes"] != float64(5) {
+		t.Errorf("expected status and bytes to be logged, got %s", lines[len(lines)-1])
+	}
+}
+
+// TestResponseWriterInterfaces tests the optional interfaces of the wrapped
+// writer are still reachable.
+func TestResponseWriterInterfaces(t *testing.T) {
+	r := NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	c := cobalt.New(&JSONEncoder{})
+	c.Get("/", func(ctx *cobalt.Context) {
+		if _, _, err := ctx.Writer().Hijack(); err != http.ErrNotSupported {
+			t.Errorf("expected hijack to be unsupported by the recorder, got %v", err)
+		}
+		if _, err := ctx.Writer().ReadFrom(strings.NewReader("streamed")); err != nil {
+			t.Errorf("expected no error from ReadFrom, got %v", err)
+		}
+		if err := http.NewResponseController(ctx.Response).Flush(); err != nil {
+			t.Errorf("expected response controller to flush, got %v", err)
+		}
+		if ctx.Writer().Size() != 8 {
+			t.Errorf("expected 8 bytes to be recorded, got %d", ctx.Writer().Size())
+		}
+	})
+
+	c.ServeHTTP(w, r)
+
+	if !w.Flushed {
+		t.Error("expected the recorder to be flushed")
+	}
+	if w.Body.String() != "streamed" {
+		t.Errorf("expected body to be streamed instead got %s", w.Body.String())
+	}
+}